@@ -0,0 +1,277 @@
+package gotorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Announce events sent to trackers.  Regular announces have no event.
+const (
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventStopped   = "stopped"
+)
+
+// Announcer announces a single torrent to its trackers for as long as it is running.  It
+// sends a started event to each tracker the first time it is used, then regular announces
+// at the interval the tracker asks for, a completed event when Completed is called and a
+// stopped event to every started tracker when it shuts down.  Failed announces are retried
+// with exponential backoff.
+type Announcer struct {
+	// Request returns the request to send, with the current port and transfer totals.
+	// The Announcer sets the info hash, event and tracker id, and a random key if the
+	// request doesn't have one.
+	Request func() TrackerRequest
+	// Response is called with each successful tracker response.  It may be nil.
+	Response func(*TrackerResponse)
+	// Transport holds the settings for HTTP trackers.  It may be nil.
+	Transport *TrackerTransport
+	// DualStack announces to each tracker over both IPv4 and IPv6, so that trackers
+	// learn both our addresses (BEP 7) without the request's Ipv4 and Ipv6 being set.
+	// NewAnnouncer enables it.  See Tracker.AnnounceDualStack.
+	DualStack bool
+
+	// DefaultInterval is used when a tracker doesn't give an interval.
+	DefaultInterval time.Duration
+	// RetryInterval is the first delay after a failed announce, and doubles on each
+	// consecutive failure up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// StopTimeout limits how long the stopped announce may take once Run's context is done.
+	StopTimeout time.Duration
+
+	metaInfo *MetaInfo
+	tiers    *TrackerTiers
+	key      string
+	// after is time.After, replaced in tests.
+	after func(time.Duration) <-chan time.Time
+
+	mu         sync.Mutex
+	trackers   map[string]*Tracker
+	trackerIds map[string]string
+	// started holds the trackers which accepted the started event.
+	started    map[string]bool
+	reannounce chan struct{}
+	completed  chan struct{}
+}
+
+// NewAnnouncer returns an Announcer for metaInfo, which sends the requests returned by
+// request.
+func NewAnnouncer(metaInfo *MetaInfo, request func() TrackerRequest) *Announcer {
+	return &Announcer{
+		Request:          request,
+		DualStack:        true,
+		DefaultInterval:  30 * time.Minute,
+		RetryInterval:    15 * time.Second,
+		MaxRetryInterval: 30 * time.Minute,
+		StopTimeout:      10 * time.Second,
+		metaInfo:         metaInfo,
+		tiers:            NewTrackerTiers(*metaInfo),
+		key:              fmt.Sprintf("%08X", randomUint32()),
+		after:            time.After,
+		trackers:         map[string]*Tracker{},
+		trackerIds:       map[string]string{},
+		started:          map[string]bool{},
+		reannounce:       make(chan struct{}, 1),
+		completed:        make(chan struct{}, 1),
+	}
+}
+
+// Tiers returns the trackers the Announcer uses.
+func (a *Announcer) Tiers() *TrackerTiers {
+	return a.tiers
+}
+
+// Reannounce asks for an announce as soon as possible, for instance because more peers are
+// needed.  The announce still waits for the tracker's min interval to pass.
+func (a *Announcer) Reannounce() {
+	select {
+	case a.reannounce <- struct{}{}:
+	default:
+	}
+}
+
+// Completed sends the completed event.  It should be called once, when the download
+// finishes; torrents which are already complete when they start never send it.
+func (a *Announcer) Completed() {
+	select {
+	case a.completed <- struct{}{}:
+	default:
+	}
+}
+
+// Run announces until ctx is done, then sends the stopped event to every tracker which was
+// told the torrent started.  It returns when the stopped announces finish.
+func (a *Announcer) Run(ctx context.Context) error {
+	if a.Request == nil {
+		return errors.New("Announcer has no Request function")
+	}
+	started, completed := false, false
+	failures := 0
+	var minInterval time.Duration
+	var lastAnnounce time.Time
+	for {
+		event := ""
+		if completed {
+			event = EventCompleted
+		}
+		resp, sent, err := a.announce(ctx, event)
+		if ctx.Err() != nil {
+			break
+		}
+		var wait time.Duration
+		if err != nil {
+			wait = a.retryInterval(failures)
+			failures++
+		} else {
+			failures = 0
+			if sent == EventCompleted {
+				completed = false
+			}
+			started = true
+			wait = time.Duration(resp.Interval) * time.Second
+			if wait <= 0 {
+				wait = a.DefaultInterval
+			}
+			minInterval = time.Duration(resp.MinInterval) * time.Second
+			lastAnnounce = time.Now()
+		}
+		if err == nil && completed {
+			// Completed was called before the tracker was sent the started event.
+			continue
+		}
+
+		timer := a.after(wait)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-timer:
+				break wait
+			case <-a.reannounce:
+				if next := lastAnnounce.Add(minInterval); time.Now().Before(next) {
+					timer = a.after(time.Until(next))
+					continue
+				}
+				break wait
+			case <-a.completed:
+				completed = true
+				if started {
+					break wait
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	a.mu.Lock()
+	var trackers []string
+	for trackerUrl := range a.started {
+		trackers = append(trackers, trackerUrl)
+	}
+	a.mu.Unlock()
+	if len(trackers) == 0 {
+		return ctx.Err()
+	}
+	stopCtx, cancel := context.WithTimeout(context.Background(), a.StopTimeout)
+	defer cancel()
+	var stopErr error
+	for _, trackerUrl := range trackers {
+		_, err := a.announceTracker(stopCtx, trackerUrl, EventStopped)
+		if err != nil && stopErr == nil {
+			stopErr = err
+		}
+		a.mu.Lock()
+		delete(a.started, trackerUrl)
+		a.mu.Unlock()
+	}
+	return stopErr
+}
+
+// retryInterval returns how long to wait after the given number of consecutive failures.
+func (a *Announcer) retryInterval(failures int) time.Duration {
+	wait := a.RetryInterval
+	for i := 0; i < failures && wait < a.MaxRetryInterval; i++ {
+		wait *= 2
+	}
+	if wait > a.MaxRetryInterval {
+		wait = a.MaxRetryInterval
+	}
+	return wait
+}
+
+// announce sends a single announce with event to the first tracker which responds, and
+// returns the event it was sent.  Trackers which haven't been started are sent the started
+// event instead.
+func (a *Announcer) announce(ctx context.Context, event string) (*TrackerResponse, string, error) {
+	var response *TrackerResponse
+	var sent string
+	err := a.tiers.Announce(func(trackerUrl string) error {
+		trackerEvent := event
+		a.mu.Lock()
+		if !a.started[trackerUrl] {
+			trackerEvent = EventStarted
+		}
+		a.mu.Unlock()
+		resp, err := a.announceTracker(ctx, trackerUrl, trackerEvent)
+		if err != nil {
+			return err
+		}
+		if trackerEvent == EventStarted {
+			a.mu.Lock()
+			a.started[trackerUrl] = true
+			a.mu.Unlock()
+		}
+		response, sent = resp, trackerEvent
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return response, sent, nil
+}
+
+// announceTracker sends a single announce with event to the tracker at trackerUrl.
+func (a *Announcer) announceTracker(ctx context.Context, trackerUrl string, event string) (*TrackerResponse, error) {
+	req := a.Request()
+	req.InfoHash = a.metaInfo.InfoHash
+	req.Event = event
+	if req.Key == "" {
+		req.Key = a.key
+	}
+	a.mu.Lock()
+	tracker, ok := a.trackers[trackerUrl]
+	if !ok {
+		tracker = NewTracker(trackerUrl)
+		tracker.Transport = a.Transport
+		a.trackers[trackerUrl] = tracker
+	}
+	req.Trackerid = a.trackerIds[trackerUrl]
+	a.mu.Unlock()
+
+	var resp *TrackerResponse
+	var err error
+	if a.DualStack {
+		resp, err = tracker.AnnounceDualStack(ctx, req)
+	} else {
+		resp, err = tracker.Announce(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.TrackerId != "" {
+		a.mu.Lock()
+		a.trackerIds[trackerUrl] = resp.TrackerId
+		a.mu.Unlock()
+	}
+	if a.Response != nil {
+		a.Response(resp)
+	}
+	return resp, nil
+}
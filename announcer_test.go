@@ -0,0 +1,224 @@
+package gotorrent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// announceTestTracker records the announces it receives, and fails the first failures.
+type announceTestTracker struct {
+	mu        sync.Mutex
+	failures  int
+	events    []string
+	trackerId []string
+}
+
+func (s *announceTestTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.events = append(s.events, r.URL.Query().Get("event"))
+	s.trackerId = append(s.trackerId, r.URL.Query().Get("trackerid"))
+	w.Write([]byte("d8:intervali1800e12:min intervali60e5:peers0:10:tracker id3:xyze"))
+}
+
+func (s *announceTestTracker) Events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+// fakeTimers replaces an Announcer's timers, recording the requested durations and
+// firing only when told to.
+type fakeTimers struct {
+	waits  chan time.Duration
+	timers chan chan time.Time
+}
+
+func newFakeTimers(a *Announcer) *fakeTimers {
+	f := &fakeTimers{make(chan time.Duration, 10), make(chan chan time.Time, 10)}
+	a.after = func(d time.Duration) <-chan time.Time {
+		timer := make(chan time.Time, 1)
+		f.waits <- d
+		f.timers <- timer
+		return timer
+	}
+	return f
+}
+
+// next waits for the Announcer to start a timer and returns its duration and channel.
+func (f *fakeTimers) next(t *testing.T) (time.Duration, chan time.Time) {
+	select {
+	case d := <-f.waits:
+		return d, <-f.timers
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Announcer didn't wait")
+		return 0, nil
+	}
+}
+
+func newTestAnnouncer(trackerUrl string) *Announcer {
+	metaInfo := &MetaInfo{Announce: trackerUrl, InfoHash: InfoHash("aaaaaaaaaaaaaaaaaaaa")}
+	return NewAnnouncer(metaInfo, func() TrackerRequest { return TestTrackerRequest })
+}
+
+func TestAnnouncerEvents(t *testing.T) {
+	tracker := &announceTestTracker{}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	a := newTestAnnouncer(server.URL + "/announce")
+	timers := newFakeTimers(a)
+	responses := 0
+	a.Response = func(*TrackerResponse) { responses++ }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	wait, timer := timers.next(t)
+	if wait != 1800*time.Second {
+		t.Errorf("Expected to wait the interval, waited %v", wait)
+	}
+	timer <- time.Now()
+	timers.next(t)
+	a.Completed()
+	_, timer = timers.next(t)
+	// The min interval hasn't passed, so the reannounce is delayed.
+	a.Reannounce()
+	wait, timer = timers.next(t)
+	if wait <= 0 || wait > 60*time.Second {
+		t.Errorf("Expected to wait for the min interval, waited %v", wait)
+	}
+	timer <- time.Now()
+	timers.next(t)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Error stopping: %v", err)
+	}
+
+	expected := []string{EventStarted, "", EventCompleted, "", EventStopped}
+	events := tracker.Events()
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v, Actual %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected %v, Actual %v", expected, events)
+		}
+	}
+	if tracker.trackerId[0] != "" || tracker.trackerId[1] != "xyz" || tracker.trackerId[4] != "xyz" {
+		t.Errorf("Tracker id wasn't echoed: %v", tracker.trackerId)
+	}
+	if responses != 5 {
+		t.Errorf("Expected 5 responses, got %v", responses)
+	}
+}
+
+func TestAnnouncerStartsEachTracker(t *testing.T) {
+	// The first tracker is down for the started event, so the second tracker accepts it.
+	first := &announceTestTracker{failures: 1}
+	second := &announceTestTracker{}
+	firstServer := httptest.NewServer(first)
+	defer firstServer.Close()
+	secondServer := httptest.NewServer(second)
+	defer secondServer.Close()
+	metaInfo := &MetaInfo{
+		Announce_List: [][]string{{firstServer.URL + "/announce"}, {secondServer.URL + "/announce"}},
+		InfoHash:      InfoHash("aaaaaaaaaaaaaaaaaaaa"),
+	}
+	a := NewAnnouncer(metaInfo, func() TrackerRequest { return TestTrackerRequest })
+	timers := newFakeTimers(a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+	_, timer := timers.next(t)
+	timer <- time.Now()
+	timers.next(t)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Error stopping: %v", err)
+	}
+
+	// The first tracker is back for the next announce, and hasn't been started yet.
+	if events := first.Events(); len(events) != 2 || events[0] != EventStarted || events[1] != EventStopped {
+		t.Errorf("Expected started and stopped on the first tracker, got %q", events)
+	}
+	if events := second.Events(); len(events) != 2 || events[0] != EventStarted || events[1] != EventStopped {
+		t.Errorf("Expected started and stopped on the second tracker, got %q", events)
+	}
+}
+
+func TestAnnouncerBackoff(t *testing.T) {
+	tracker := &announceTestTracker{failures: 3}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	a := newTestAnnouncer(server.URL + "/announce")
+	a.RetryInterval = time.Second
+	a.MaxRetryInterval = 3 * time.Second
+	timers := newFakeTimers(a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+
+	// Completed before the torrent has started is sent after the started event.
+	a.Completed()
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 1800 * time.Second} {
+		wait, timer := timers.next(t)
+		if wait != expected {
+			t.Errorf("Expected to wait %v, waited %v", expected, wait)
+		}
+		timer <- time.Now()
+	}
+	timers.next(t)
+	cancel()
+	<-done
+
+	expected := []string{EventStarted, EventCompleted, "", EventStopped}
+	events := tracker.Events()
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v, Actual %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected %v, Actual %v", expected, events)
+		}
+	}
+}
+
+func TestAnnouncerNeverStarted(t *testing.T) {
+	tracker := &announceTestTracker{failures: 100}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	a := newTestAnnouncer(server.URL + "/announce")
+	timers := newFakeTimers(a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+	timers.next(t)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+	if events := tracker.Events(); len(events) != 0 {
+		t.Errorf("Unexpected announces %v", events)
+	}
+}
+
+func TestAnnouncerNoRequest(t *testing.T) {
+	a := newTestAnnouncer("http://127.0.0.1:1/announce")
+	a.Request = nil
+	if err := a.Run(context.Background()); err == nil {
+		t.Errorf("Expected an error without a Request function")
+	}
+}
@@ -7,16 +7,24 @@ import (
 	"strconv"
 )
 
+// Marshaler is implemented by types which can marshal themselves into a bencoded string.
+type Marshaler interface {
+	MarshalBencode() (string, error)
+}
+
 // Marshal takes the given Go datastructure and converts it to a bencoded string.
 // See https://wiki.theory.org/BitTorrentSpecification#Bencoding for details about bencoding.
 func Marshal(source interface{}) (bencoded_string string, err error) {
+	if m, ok := source.(Marshaler); ok {
+		return m.MarshalBencode()
+	}
 	value := reflect.ValueOf(source)
 	switch value.Kind() {
 	case reflect.Int:
-		i := source.(int)
+		i := int(value.Int())
 		return "i" + strconv.Itoa(i) + "e", nil
 	case reflect.String:
-		s := source.(string)
+		s := value.String()
 		return strconv.Itoa(len(s)) + ":" + s, nil
 	case reflect.Array, reflect.Slice:
 		listString := "l"
@@ -53,19 +61,28 @@ func Marshal(source interface{}) (bencoded_string string, err error) {
 	case reflect.Map:
 		marshalledMap := map[string]string{}
 		marshalledKeys := []string{}
+		// Dicts with string keys must be sorted by the raw keys, which is not the same as
+		// sorting the marshalled keys since those start with the key length.
+		sortKeys := map[string]string{}
 		for _, keyValue := range value.MapKeys() {
 			marshalledKey, err := Marshal(keyValue.Interface())
 			if err != nil {
 				return "", err
 			}
 			marshalledKeys = append(marshalledKeys, marshalledKey)
+			sortKeys[marshalledKey] = marshalledKey
+			if keyValue.Kind() == reflect.String {
+				sortKeys[marshalledKey] = keyValue.String()
+			}
 			marshalledValue, err := Marshal(value.MapIndex(keyValue).Interface())
 			if err != nil {
 				return "", err
 			}
 			marshalledMap[marshalledKey] = marshalledValue
 		}
-		sort.Strings(marshalledKeys)
+		sort.Slice(marshalledKeys, func(i, j int) bool {
+			return sortKeys[marshalledKeys[i]] < sortKeys[marshalledKeys[j]]
+		})
 		dictString := "d"
 		for _, marshalledKey := range marshalledKeys {
 			dictString += marshalledKey + marshalledMap[marshalledKey]
@@ -33,4 +33,7 @@ func TestMarshal(t *testing.T) {
 		map[TestList]int{TestList{"alice", 30}: 35, TestList{"bob", 25}: 30},
 		"dd4:name3:bob3:agei25eei30ed4:name5:alice3:agei30eei35ee", t)
 	ValidateMarshal([]int{10, 20, 30}, "li10ei20ei30ee", t)
+	ValidateMarshal([]TestString{"a", "bc"}, "l1:a2:bce", t)
 }
+
+type TestString string
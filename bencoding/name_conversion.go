@@ -5,10 +5,10 @@ import "unicode"
 
 func ToLowerCaseWithSpaces(s string) (lower string) {
 	for i, r := range s {
-		if unicode.IsLower(r) {
+		if unicode.IsLower(r) || unicode.IsDigit(r) {
 			lower += string(r)
 		} else if unicode.IsUpper(r) {
-			if i != 0 {
+			if i != 0 && s[i-1] != '_' {
 				lower += " "
 			}
 			lower += string(unicode.ToLower(r))
@@ -21,10 +21,10 @@ func ToLowerCaseWithSpaces(s string) (lower string) {
 
 func ToLowerCaseWithUnderscores(s string) (lower string) {
 	for i, r := range s {
-		if unicode.IsLower(r) {
+		if unicode.IsLower(r) || unicode.IsDigit(r) {
 			lower += string(r)
 		} else if unicode.IsUpper(r) {
-			if i != 0 {
+			if i != 0 && s[i-1] != '_' {
 				lower += "_"
 			}
 			lower += string(unicode.ToLower(r))
@@ -43,7 +43,7 @@ func ToCamelCase(s string) (camel string) {
 			previousRuneWasSpace = false
 		} else if unicode.IsSpace(r) {
 			previousRuneWasSpace = true
-		} else if string(r) == "-" {
+		} else if string(r) == "-" || string(r) == "." {
 			camel += "_"
 			previousRuneWasSpace = true
 		} else {
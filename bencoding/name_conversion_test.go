@@ -0,0 +1,25 @@
+package bencoding
+
+import "testing"
+
+func TestNameConversion(t *testing.T) {
+	names := []struct{ camel, spaces, underscores string }{
+		{"PieceLength", "piece length", "piece_length"},
+		{"Announce_List", "announce-list", "announce-list"},
+		{"Ipv6", "ipv6", "ipv6"},
+	}
+	for _, name := range names {
+		if actual := ToLowerCaseWithSpaces(name.camel); actual != name.spaces {
+			t.Errorf("Expected %v, Actual %v", name.spaces, actual)
+		}
+		if actual := ToLowerCaseWithUnderscores(name.camel); actual != name.underscores {
+			t.Errorf("Expected %v, Actual %v", name.underscores, actual)
+		}
+		if actual := ToCamelCase(name.spaces); actual != name.camel {
+			t.Errorf("Expected %v, Actual %v", name.camel, actual)
+		}
+	}
+	if actual := ToCamelCase("path.utf-8"); actual != "Path_Utf_8" {
+		t.Errorf("Expected Path_Utf_8, Actual %v", actual)
+	}
+}
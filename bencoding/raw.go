@@ -0,0 +1,27 @@
+package bencoding
+
+import "fmt"
+
+// RawMessage is a complete bencoded value.  Unmarshalling into a RawMessage stores the
+// value without decoding it, and marshalling a RawMessage emits it unchanged, so values
+// can be passed through byte-for-byte.
+type RawMessage string
+
+func (m RawMessage) MarshalBencode() (string, error) {
+	if m == "" {
+		return "", fmt.Errorf("Can't marshal empty RawMessage")
+	}
+	return string(m), nil
+}
+
+func (m *RawMessage) UnmarshalBencode(s string) error {
+	token, leftovers, err := getOneToken(s)
+	if err != nil {
+		return err
+	}
+	if leftovers != "" {
+		return fmt.Errorf("Unconsumed inputs in %v when unmarshaling to RawMessage", leftovers)
+	}
+	*m = RawMessage(token)
+	return nil
+}
@@ -0,0 +1,20 @@
+package bencoding
+
+import "testing"
+
+func TestRawMessageRoundTrip(t *testing.T) {
+	input := "d3:agei30e4:infod1:ai1e1:bi2ee4:name5:alicee"
+	actual := map[string]RawMessage{}
+	if err := Unmarshal(input, &actual); err != nil {
+		t.Fatalf("Error unmarshalling %v: %v", input, err)
+	}
+	if actual["info"] != "d1:ai1e1:bi2ee" {
+		t.Errorf("Expected raw info dict, Actual %v", actual["info"])
+	}
+	ValidateMarshal(actual, input, t)
+}
+
+func TestMarshalSortsStringKeys(t *testing.T) {
+	// Sorting the marshalled keys would put "10:..." before "3:...".
+	ValidateMarshal(map[string]int{"zzz": 1, "aaaaaaaaaa": 2}, "d10:aaaaaaaaaai2e3:zzzi1ee", t)
+}
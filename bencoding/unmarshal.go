@@ -8,9 +8,18 @@ import (
 	"strings"
 )
 
+// Unmarshaler is implemented by types which can unmarshal a bencoded representation of
+// themselves.  The input is a single complete bencoded value.
+type Unmarshaler interface {
+	UnmarshalBencode(s string) error
+}
+
 // Unmarshal takes a bencoded string and a target object, and fills out the target object
 // with the values from the bencoded string.  The structure of the target object must match
 // the structure of the string.  Slices will be automatically sized.
+// Dict keys with no matching struct field are stored in the struct's Extra field if it has
+// one, which must be a map with string keys (typically map[string]RawMessage), and are
+// otherwise ignored.
 // See https://wiki.theory.org/BitTorrentSpecification#Bencoding for details about bencoding.
 // TODO(apm): Lots of string copies in here, look into optimizations.
 func Unmarshal(s string, v interface{}) error {
@@ -22,13 +31,20 @@ func Unmarshal(s string, v interface{}) error {
 		return fmt.Errorf("Must pass a pointer or struct to Unmarshal, received %v", ptrValue)
 	}
 
+	if len(s) == 0 {
+		return fmt.Errorf("Can't unmarshal empty string to %v", v)
+	}
+	if u, ok := v.(Unmarshaler); ok {
+		return u.UnmarshalBencode(s)
+	}
+
 	value := ptrValue.Elem()
 	if !value.CanSet() {
 		return fmt.Errorf("Received unsettable value %v", v)
 	}
 	switch value.Kind() {
 	case reflect.Int:
-		if s[0] != 'i' || s[len(s)-1] != 'e' {
+		if len(s) < 2 || s[0] != 'i' || s[len(s)-1] != 'e' {
 			return fmt.Errorf("Expected integer for %v, found %v", v, s)
 		}
 		s = s[1 : len(s)-1]
@@ -53,7 +69,7 @@ func Unmarshal(s string, v interface{}) error {
 		value.SetString(substrings[1])
 		return nil
 	case reflect.Array, reflect.Slice:
-		if s[0] != 'l' || s[len(s)-1] != 'e' {
+		if len(s) < 2 || s[0] != 'l' || s[len(s)-1] != 'e' {
 			return fmt.Errorf("Expected list for %v, found %v", v, s)
 		}
 		s = s[1 : len(s)-1]
@@ -85,7 +101,7 @@ func Unmarshal(s string, v interface{}) error {
 		}
 		return nil
 	case reflect.Struct:
-		if s[0] != 'd' || s[len(s)-1] != 'e' {
+		if len(s) < 2 || s[0] != 'd' || s[len(s)-1] != 'e' {
 			return fmt.Errorf("Expected dict for %v, found %v", v, s)
 		}
 		s = s[1 : len(s)-1]
@@ -111,8 +127,15 @@ func Unmarshal(s string, v interface{}) error {
 			s = leftovers
 
 			field := value.FieldByName(fieldName)
+			// The Extra field only holds unknown keys, so a key that happens to be called
+			// "extra" is one of them.
+			if fieldName == extraField {
+				field = reflect.Value{}
+			}
 			if !field.IsValid() {
-				// TODO(apm): Figure out something better to do with unknown fields.
+				if err := unmarshalExtra(value, unmarshalledFieldName, token); err != nil {
+					return err
+				}
 				continue
 			}
 			if !field.CanSet() {
@@ -136,10 +159,13 @@ func Unmarshal(s string, v interface{}) error {
 		}
 		return nil
 	case reflect.Map:
-		if s[0] != 'd' || s[len(s)-1] != 'e' {
+		if len(s) < 2 || s[0] != 'd' || s[len(s)-1] != 'e' {
 			return fmt.Errorf("Expected map for %v, found %v", v, s)
 		}
 		s = s[1 : len(s)-1]
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
 		for len(s) > 0 {
 			key, leftovers, err := getOneToken(s)
 			if err != nil {
@@ -171,8 +197,35 @@ func Unmarshal(s string, v interface{}) error {
 	}
 }
 
+// extraField is the name of the field holding dict keys with no matching field.
+const extraField = "Extra"
+
+// unmarshalExtra stores the value of a dict key with no matching field in the Extra field
+// of value, if it has one.
+func unmarshalExtra(value reflect.Value, key, token string) error {
+	extra := value.FieldByName(extraField)
+	if !extra.IsValid() || extra.Kind() != reflect.Map || extra.Type().Key().Kind() != reflect.String {
+		return nil
+	}
+	if !extra.CanSet() {
+		return fmt.Errorf("Received unsettable Extra field in %v", value)
+	}
+	if extra.IsNil() {
+		extra.Set(reflect.MakeMap(extra.Type()))
+	}
+	elem := reflect.New(extra.Type().Elem())
+	if err := Unmarshal(token, elem.Interface()); err != nil {
+		return err
+	}
+	extra.SetMapIndex(reflect.ValueOf(key).Convert(extra.Type().Key()), elem.Elem())
+	return nil
+}
+
 // TODO(apm): This would be a lot cleaner if we built a syntax tree.
 func getOneToken(s string) (token, leftovers string, err error) {
+	if len(s) == 0 {
+		return "", "", fmt.Errorf("Missing token")
+	}
 	switch s[0] {
 	case 'i':
 		substrings := strings.SplitAfterN(s, "e", 2)
@@ -206,6 +259,9 @@ func getOneToken(s string) (token, leftovers string, err error) {
 		if err != nil {
 			return "", "", fmt.Errorf("Couldn't parse length of string %v: %v", s, err)
 		}
+		if length < 0 || length > len(s)-colonIndex-1 {
+			return "", "", fmt.Errorf("Invalid length %v for string in %v", length, s)
+		}
 		tokenLength := colonIndex + length + 1
 		return s[:tokenLength], s[tokenLength:], nil
 	}
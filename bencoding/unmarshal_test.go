@@ -180,3 +180,117 @@ func TestUnmarshalMap(t *testing.T) {
 		}
 	}
 }
+
+type TestUnmarshaler struct {
+	Input string
+}
+
+func (u *TestUnmarshaler) UnmarshalBencode(s string) error {
+	u.Input = s
+	return nil
+}
+
+type TestListWithUnmarshaler struct {
+	Name string
+	Kids TestUnmarshaler
+}
+
+func TestUnmarshalCallsUnmarshaler(t *testing.T) {
+	actual := TestListWithUnmarshaler{}
+	input := "d4:name5:alice4:kidsl3:bob5:carolee"
+	expected := TestListWithUnmarshaler{"alice", TestUnmarshaler{"l3:bob5:carole"}}
+	err := Unmarshal(input, &actual)
+	ValidateUnmarshal(input, expected, actual, err, t)
+}
+
+func TestUnmarshalEmptyString(t *testing.T) {
+	var i int
+	if err := Unmarshal("", &i); err == nil {
+		t.Errorf("Expected an error unmarshalling an empty string")
+	}
+	var s struct{ Name string }
+	if err := Unmarshal("", &s); err == nil {
+		t.Errorf("Expected an error unmarshalling an empty string")
+	}
+}
+
+type TestListWithExtra struct {
+	Name  string
+	Extra map[string]RawMessage
+}
+
+func TestUnmarshalStructKeepsExtra(t *testing.T) {
+	actual := TestListWithExtra{}
+	input := "d3:agei30e4:kidsl3:bobe4:name5:alicee"
+	err := Unmarshal(input, &actual)
+	if err != nil {
+		t.Fatalf("Error unmarshalling %v: %v", input, err)
+	}
+	if actual.Name != "alice" || len(actual.Extra) != 2 ||
+		actual.Extra["age"] != "i30e" || actual.Extra["kids"] != "l3:bobe" {
+		t.Errorf("Unexpected result %v on input %v", actual, input)
+	}
+}
+
+func TestUnmarshalStructKeepsExtraKey(t *testing.T) {
+	// A key called "extra" is an unknown key like any other, rather than the Extra field.
+	actual := TestListWithExtra{}
+	input := "d5:extrai1e4:name5:alicee"
+	if err := Unmarshal(input, &actual); err != nil {
+		t.Fatalf("Error unmarshalling %v: %v", input, err)
+	}
+	if actual.Name != "alice" || len(actual.Extra) != 1 || actual.Extra["extra"] != "i1e" {
+		t.Errorf("Unexpected result %v on input %v", actual, input)
+	}
+}
+
+// TestFuzzTarget has a field of each kind Unmarshal fills.
+type TestFuzzTarget struct {
+	Name     string
+	Age      int
+	Kids     []string
+	Scores   map[string]int
+	Info     TestListWithExtra
+	InfoHash string
+	Raw      RawMessage
+	Extra    map[string]RawMessage
+}
+
+var truncatedInputs = []string{
+	"d5:addedl100:http://x/ee",
+	"d8:intervali5e5:peers100:abce",
+	"4:abc",
+	"-1:abc",
+	"d4:name-5:alicee",
+	"d4:name",
+	"l",
+	"i",
+	"d",
+	"d4:infod4:name5:alicee",
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	for _, input := range truncatedInputs {
+		var actual TestFuzzTarget
+		if err := Unmarshal(input, &actual); err == nil {
+			t.Errorf("%q: Expected an error, got %+v", input, actual)
+		}
+		var raw RawMessage
+		if err := Unmarshal(input, &raw); err == nil {
+			t.Errorf("%q: Expected an error, got %q", input, raw)
+		}
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add("d4:name5:alice3:agei30e4:kidsl3:bob5:carole6:scoresd1:ai1ee4:infod4:name3:bobe3:raw3:abc1:xi1ee")
+	for _, input := range truncatedInputs {
+		f.Add(input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		var actual TestFuzzTarget
+		Unmarshal(input, &actual)
+		var raw RawMessage
+		Unmarshal(input, &raw)
+	})
+}
@@ -0,0 +1,202 @@
+// Package bitfield provides a compact set of piece indexes, encoded on the wire as in the
+// bitfield message of the peer wire protocol: the high bit of the first byte is piece 0.
+package bitfield
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Bitfield holds one bit for each piece of a torrent.  The zero value is an empty
+// bitfield of length 0.
+type Bitfield struct {
+	bits   []byte
+	length int
+}
+
+// New returns a bitfield of length bits, all clear.
+func New(length int) *Bitfield {
+	return &Bitfield{make([]byte, (length+7)/8), length}
+}
+
+// FromBytes decodes a bitfield of length bits from its wire encoding.  The encoding must
+// have exactly enough bytes, and the spare bits at the end must be clear.
+func FromBytes(b []byte, length int) (*Bitfield, error) {
+	if len(b) != (length+7)/8 {
+		return nil, fmt.Errorf("Bitfield is %v bytes for %v bits", len(b), length)
+	}
+	if length%8 != 0 && b[len(b)-1]&(0xff>>uint(length%8)) != 0 {
+		return nil, errors.New("Bitfield has spare bits set")
+	}
+	return &Bitfield{append([]byte(nil), b...), length}, nil
+}
+
+// Bytes returns the wire encoding of b.
+func (b *Bitfield) Bytes() []byte {
+	return append([]byte(nil), b.bits...)
+}
+
+// Len returns the number of bits in b.
+func (b *Bitfield) Len() int {
+	return b.length
+}
+
+// Clone returns a copy of b.
+func (b *Bitfield) Clone() *Bitfield {
+	return &Bitfield{b.Bytes(), b.length}
+}
+
+// Get reports whether bit i is set.  Bits outside the bitfield are never set.
+func (b *Bitfield) Get(i int) bool {
+	return i >= 0 && i < b.length && b.bits[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// Set sets bit i.
+func (b *Bitfield) Set(i int) {
+	b.check(i)
+	b.bits[i/8] |= 0x80 >> uint(i%8)
+}
+
+// Clear clears bit i.
+func (b *Bitfield) Clear(i int) {
+	b.check(i)
+	b.bits[i/8] &^= 0x80 >> uint(i%8)
+}
+
+func (b *Bitfield) check(i int) {
+	if i < 0 || i >= b.length {
+		panic(fmt.Sprintf("bitfield: index %v out of range [0, %v)", i, b.length))
+	}
+}
+
+// SetAll sets every bit.
+func (b *Bitfield) SetAll() {
+	for i := range b.bits {
+		b.bits[i] = 0xff
+	}
+	if b.length%8 != 0 {
+		b.bits[len(b.bits)-1] = 0xff << uint(8-b.length%8)
+	}
+}
+
+// Count returns the number of bits set.
+func (b *Bitfield) Count() int {
+	count := 0
+	for _, x := range b.bits {
+		count += bits.OnesCount8(x)
+	}
+	return count
+}
+
+// All reports whether every bit is set.
+func (b *Bitfield) All() bool {
+	return b.Count() == b.length
+}
+
+// Equal reports whether b and other have the same length and bits.
+func (b *Bitfield) Equal(other *Bitfield) bool {
+	return b.length == other.length && string(b.bits) == string(other.bits)
+}
+
+// combine returns the result of applying op to each byte of b and other, which must have
+// the same length.
+func (b *Bitfield) combine(other *Bitfield, op func(x, y byte) byte) *Bitfield {
+	if b.length != other.length {
+		panic(fmt.Sprintf("bitfield: lengths %v and %v differ", b.length, other.length))
+	}
+	result := New(b.length)
+	for i := range b.bits {
+		result.bits[i] = op(b.bits[i], other.bits[i])
+	}
+	return result
+}
+
+// And returns the bits set in both b and other.
+func (b *Bitfield) And(other *Bitfield) *Bitfield {
+	return b.combine(other, func(x, y byte) byte { return x & y })
+}
+
+// Or returns the bits set in either b or other.
+func (b *Bitfield) Or(other *Bitfield) *Bitfield {
+	return b.combine(other, func(x, y byte) byte { return x | y })
+}
+
+// AndNot returns the bits set in b but not in other, such as the pieces a peer has that
+// we don't.
+func (b *Bitfield) AndNot(other *Bitfield) *Bitfield {
+	return b.combine(other, func(x, y byte) byte { return x &^ y })
+}
+
+// NextSet returns the first set bit at or after i, or -1 if there is none.  The set bits
+// can be iterated over with
+//
+//	for i := b.NextSet(0); i >= 0; i = b.NextSet(i + 1)
+func (b *Bitfield) NextSet(i int) int {
+	return b.next(i, 0)
+}
+
+// NextClear returns the first clear bit at or after i, or -1 if there is none.
+func (b *Bitfield) NextClear(i int) int {
+	return b.next(i, 0xff)
+}
+
+// next returns the first bit at or after i which is set in b.bits^flip.
+func (b *Bitfield) next(i int, flip byte) int {
+	if i < 0 {
+		i = 0
+	}
+	for i < b.length {
+		x := (b.bits[i/8] ^ flip) << uint(i%8)
+		if x != 0 {
+			i += bits.LeadingZeros8(x)
+			if i >= b.length {
+				return -1
+			}
+			return i
+		}
+		i += 8 - i%8
+	}
+	return -1
+}
+
+// Run is a series of consecutive bits which are all set or all clear.
+type Run struct {
+	Set    bool
+	Start  int
+	Length int
+}
+
+// Runs returns b as a series of runs, for display.
+func (b *Bitfield) Runs() []Run {
+	var runs []Run
+	for i := 0; i < b.length; {
+		set := b.Get(i)
+		end := b.NextClear(i)
+		if !set {
+			end = b.NextSet(i)
+		}
+		if end < 0 {
+			end = b.length
+		}
+		runs = append(runs, Run{set, i, end - i})
+		i = end
+	}
+	return runs
+}
+
+// String lists the set bits as ranges, for instance "0-3,7,9-10".
+func (b *Bitfield) String() string {
+	var ranges []string
+	for _, run := range b.Runs() {
+		switch {
+		case !run.Set:
+		case run.Length == 1:
+			ranges = append(ranges, fmt.Sprint(run.Start))
+		default:
+			ranges = append(ranges, fmt.Sprintf("%v-%v", run.Start, run.Start+run.Length-1))
+		}
+	}
+	return strings.Join(ranges, ",")
+}
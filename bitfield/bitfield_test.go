@@ -0,0 +1,126 @@
+package bitfield
+
+import (
+	"reflect"
+	"testing"
+)
+
+func fromString(s string) *Bitfield {
+	b := New(len(s))
+	for i, c := range s {
+		if c == '1' {
+			b.Set(i)
+		}
+	}
+	return b
+}
+
+func TestBitfield(t *testing.T) {
+	b := New(10)
+	b.Set(0)
+	b.Set(2)
+	b.Set(9)
+	b.Set(5)
+	b.Clear(5)
+	if expected := "\xa0\x40"; string(b.Bytes()) != expected {
+		t.Errorf("Expected %q, Actual %q", expected, b.Bytes())
+	}
+	if !b.Get(0) || b.Get(1) || !b.Get(9) || b.Get(10) || b.Get(-1) {
+		t.Errorf("Unexpected bits %v", b)
+	}
+	if b.Len() != 10 || b.Count() != 3 || b.All() {
+		t.Errorf("Unexpected length %v or count %v", b.Len(), b.Count())
+	}
+	b.SetAll()
+	if !b.All() || string(b.Bytes()) != "\xff\xc0" {
+		t.Errorf("Expected every bit to be set, got %q", b.Bytes())
+	}
+
+	c := b.Clone()
+	c.Clear(3)
+	if !b.Get(3) || b.Equal(c) {
+		t.Errorf("Expected the clone to be independent")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic setting a spare bit")
+		}
+	}()
+	b.Set(10)
+}
+
+func TestFromBytes(t *testing.T) {
+	b, err := FromBytes([]byte{0xa0, 0x40}, 10)
+	if err != nil || !b.Equal(fromString("1010000001")) {
+		t.Errorf("Unexpected bitfield %v, %v", b, err)
+	}
+	if b, err := FromBytes([]byte{0xff}, 8); err != nil || !b.All() {
+		t.Errorf("Unexpected bitfield %v, %v", b, err)
+	}
+	for _, test := range []struct {
+		b      []byte
+		length int
+	}{
+		{[]byte{0xff}, 10},
+		{[]byte{0xff, 0xc0, 0x00}, 10},
+		{[]byte{0xff, 0xe0}, 10},
+		{[]byte{0x01}, 7},
+	} {
+		if _, err := FromBytes(test.b, test.length); err == nil {
+			t.Errorf("%x, %v: Expected an error", test.b, test.length)
+		}
+	}
+}
+
+func TestBitfieldOperations(t *testing.T) {
+	a := fromString("1100110011")
+	b := fromString("1010101010")
+	if actual := a.And(b); !actual.Equal(fromString("1000100010")) {
+		t.Errorf("Unexpected And %v", actual)
+	}
+	if actual := a.Or(b); !actual.Equal(fromString("1110111011")) {
+		t.Errorf("Unexpected Or %v", actual)
+	}
+	if actual := a.AndNot(b); !actual.Equal(fromString("0100010001")) {
+		t.Errorf("Unexpected AndNot %v", actual)
+	}
+}
+
+func TestBitfieldIteration(t *testing.T) {
+	b := fromString("01100000000000000101")
+	var set, clear []int
+	for i := b.NextSet(0); i >= 0; i = b.NextSet(i + 1) {
+		set = append(set, i)
+	}
+	for i := b.NextClear(0); i >= 0; i = b.NextClear(i + 1) {
+		clear = append(clear, i)
+	}
+	if expected := []int{1, 2, 17, 19}; !reflect.DeepEqual(expected, set) {
+		t.Errorf("Expected %v, Actual %v", expected, set)
+	}
+	if len(clear) != 16 || clear[0] != 0 || clear[15] != 18 {
+		t.Errorf("Unexpected clear bits %v", clear)
+	}
+	// The spare bits at the end are never returned.
+	if i := fromString("1111111111").NextClear(0); i != -1 {
+		t.Errorf("Expected no clear bits, got %v", i)
+	}
+	if i := New(0).NextSet(0); i != -1 {
+		t.Errorf("Expected no set bits, got %v", i)
+	}
+}
+
+func TestBitfieldRuns(t *testing.T) {
+	b := fromString("11110001011")
+	expected := []Run{{true, 0, 4}, {false, 4, 3}, {true, 7, 1}, {false, 8, 1}, {true, 9, 2}}
+	if actual := b.Runs(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+	if expected := "0-3,7,9-10"; b.String() != expected {
+		t.Errorf("Expected %v, Actual %v", expected, b.String())
+	}
+	if s := New(5).String(); s != "" {
+		t.Errorf("Expected an empty string, got %v", s)
+	}
+}
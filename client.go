@@ -1,18 +1,16 @@
 package gotorrent
 
 import (
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"context"
 	"net/url"
-	"reflect"
 	"strconv"
-
-	"github.com/optimality/gotorrent/bencoding"
+	"strings"
 )
 
+// TrackerRequest holds the parameters of an announce.  Empty strings and zero counts
+// for optional parameters are left out of the request.
 type TrackerRequest struct {
-	InfoHash   string
+	InfoHash   InfoHash
 	PeerId     string
 	Port       string
 	Uploaded   int
@@ -21,16 +19,33 @@ type TrackerRequest struct {
 	Compact    bool
 	NoPeerId   bool
 	Event      string
-	// IP         string
-	// Numwant    int
-	// Key        int
-	// Trackerid  int
+	// Ip is the address peers should connect to, when it differs from the address the
+	// request comes from.  Ipv4 and Ipv6 give an address in each family (BEP 7), so a
+	// dual-stack client can be reached over both.
+	Ip   string
+	Ipv4 string
+	Ipv6 string
+	// Numwant is the number of peers wanted.  Zero leaves it to the tracker.
+	Numwant int
+	// Key identifies the client to the tracker if its IP address changes.  It should be
+	// random and the same for every announce.
+	Key string
+	// Trackerid echoes the tracker id from the tracker's previous response.
+	Trackerid string
+	// Supportcrypto says that the client supports encrypted connections.
+	Supportcrypto bool
+	// Corrupt and Redundant are the number of bytes downloaded which failed their hash
+	// checks, and which were downloaded more than once.
+	Corrupt   int
+	Redundant int
 }
 
+// TestTrackerRequest is a starting point for announces, with a peer id which is new for
+// each process.
 var TestTrackerRequest = TrackerRequest{
-	PeerId: "G001-----ABCDEFGHIJK",
-	Port:   "6881",
-	Event:  "started",
+	PeerId: NewPeerId(),
+	Port:   DefaultPort,
+	Event:  EventStarted,
 }
 
 type TrackerResponse struct {
@@ -41,79 +56,106 @@ type TrackerResponse struct {
 	TrackerId      string
 	Complete       int
 	Incomplete     int
-	Peers          string
+	Peers          PeerList
+	Peers6         Peer6List
 }
 
-func UrlEncodeStruct(s interface{}) (m map[string][]string, err error) {
-	m = map[string][]string{}
-	value := reflect.ValueOf(s)
-	switch value.Kind() {
-	case reflect.Struct:
-		for i := 0; i < value.NumField(); i++ {
-			fieldName := bencoding.ToLowerCaseWithUnderscores(value.Type().Field(i).Name)
-			var fieldValue []string
-			field := value.Field(i)
-			switch field.Kind() {
-			case reflect.String:
-				fieldValue = append(fieldValue, field.String())
-			case reflect.Int:
-				fieldValue = append(fieldValue, strconv.Itoa(int(field.Int())))
-			case reflect.Bool:
-				if field.Bool() {
-					fieldValue = append(fieldValue, "1")
-				} else {
-					fieldValue = append(fieldValue, "0")
-				}
-			}
-			if err != nil {
-				return nil, err
-			}
-			m[fieldName] = fieldValue
-		}
-	default:
-		return m, fmt.Errorf("Invalid argument: %v", s)
-	}
-	return m, nil
+// AllPeers returns the IPv4 and IPv6 peers in the response.
+func (r *TrackerResponse) AllPeers() []PeerAddr {
+	peers := append([]PeerAddr(nil), r.Peers...)
+	return append(peers, r.Peers6...)
 }
 
-func QueryTracker(metaInfo MetaInfo) error {
-	trackerRequest := TestTrackerRequest
-	trackerRequest.InfoHash = metaInfo.InfoHash
-	trackerRequest.Left = metaInfo.Info.Length
-	urlEncodedTrackerRequest, err := UrlEncodeStruct(trackerRequest)
-	if err != nil {
-		return fmt.Errorf("Couldn't encode tracker request %v, err %v", trackerRequest, err)
+// Query returns req encoded as a url query.  Unlike url.Values.Encode, every byte of the
+// binary info_hash and peer_id which isn't unreserved is percent-encoded, and parameters
+// are kept in a fixed order.
+func (req TrackerRequest) Query() string {
+	params := []string{
+		"info_hash=" + escapeQueryBytes(string(req.InfoHash)),
+		"peer_id=" + escapeQueryBytes(req.PeerId),
+		"port=" + escapeQueryBytes(req.Port),
+		"uploaded=" + strconv.Itoa(req.Uploaded),
+		"downloaded=" + strconv.Itoa(req.Downloaded),
+		"left=" + strconv.Itoa(req.Left),
 	}
-
-	tracker_url, err := url.Parse(metaInfo.Announce)
-	if err != nil {
-		return fmt.Errorf("Couldn't parse url %v", metaInfo.Announce)
+	if req.Compact {
+		params = append(params, "compact=1")
+	} else {
+		params = append(params, "compact=0")
 	}
-	values := tracker_url.Query()
-	for key, value := range urlEncodedTrackerRequest {
-		for _, v := range value {
-			values.Add(key, v)
+	if req.NoPeerId {
+		params = append(params, "no_peer_id=1")
+	}
+	for _, param := range []struct{ key, value string }{
+		{"event", req.Event},
+		{"ip", req.Ip},
+		{"ipv4", req.Ipv4},
+		{"ipv6", req.Ipv6},
+		{"key", req.Key},
+		{"trackerid", req.Trackerid},
+	} {
+		if param.value != "" {
+			params = append(params, param.key+"="+escapeQueryBytes(param.value))
 		}
 	}
-	tracker_url.RawQuery = values.Encode()
-
-	fmt.Printf("querying %v\n", tracker_url)
-	resp, err := http.Get(tracker_url.String())
-	if err != nil {
-		return err
+	if req.Numwant > 0 {
+		params = append(params, "numwant="+strconv.Itoa(req.Numwant))
 	}
-	defer resp.Body.Close()
-	fmt.Println(resp)
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if req.Supportcrypto {
+		params = append(params, "supportcrypto=1")
+	}
+	if req.Corrupt > 0 {
+		params = append(params, "corrupt="+strconv.Itoa(req.Corrupt))
 	}
-	var trackerResponse TrackerResponse
-	bencoding.Unmarshal(string(body), &trackerResponse)
-	fmt.Println(trackerResponse)
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Non-200 response: %v", resp)
+	if req.Redundant > 0 {
+		params = append(params, "redundant="+strconv.Itoa(req.Redundant))
 	}
+	return strings.Join(params, "&")
+}
 
-	return nil
+// escapeQueryBytes percent-encodes every byte of s except the unreserved characters of
+// RFC 3986.
+func escapeQueryBytes(s string) string {
+	const hex = "0123456789ABCDEF"
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			escaped.WriteByte('%')
+			escaped.WriteByte(hex[c>>4])
+			escaped.WriteByte(hex[c&15])
+		}
+	}
+	return escaped.String()
+}
+
+// appendQuery adds query to the query of u, leaving any parameters already in the url,
+// such as a private tracker's passkey, exactly as they were.
+func appendQuery(u *url.URL, query string) {
+	if u.RawQuery == "" {
+		u.RawQuery = query
+	} else {
+		u.RawQuery += "&" + query
+	}
+}
+
+// QueryTracker announces metaInfo to the trackers in tiers, trying them in the order
+// given by the tiers, and returns the first successful response.  The same tiers should
+// be used for each announce of a torrent, so that the order they learn persists.
+func QueryTracker(ctx context.Context, metaInfo MetaInfo, tiers *TrackerTiers) (*TrackerResponse, error) {
+	trackerRequest := TestTrackerRequest
+	trackerRequest.InfoHash = metaInfo.InfoHash
+	trackerRequest.Left = metaInfo.Info.TotalLength()
+	trackerRequest.Compact = true
+
+	var trackerResponse *TrackerResponse
+	err := tiers.Announce(func(trackerUrl string) error {
+		var err error
+		trackerResponse, err = NewTracker(trackerUrl).Announce(ctx, trackerRequest)
+		return err
+	})
+	return trackerResponse, err
 }
@@ -1,5 +1,12 @@
 package gotorrent
 
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
 // func TestQueryTracker(t *testing.T) {
 // 	testFile := "ubuntu-14.10-desktop-amd64.iso.torrent"
 // 	b, err := ioutil.ReadFile("testData/" + testFile)
@@ -11,8 +18,62 @@ package gotorrent
 // 	if err != nil {
 // 		t.Errorf("Unable to unmarshal %v: %v", string(b), err)
 // 	}
-// 	err = QueryTracker(metaInfo)
+// 	_, err = QueryTracker(context.Background(), metaInfo, NewTrackerTiers(metaInfo))
 // 	if err != nil {
 // 		t.Errorf("Error in query: %v", err)
 // 	}
 // }
+
+func TestQueryTrackerKeepsTierOrder(t *testing.T) {
+	downRequests := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downRequests++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali900e5:peers0:e"))
+	}))
+	defer up.Close()
+
+	var metaInfo MetaInfo
+	metaInfo.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	metaInfo.Announce_List = [][]string{{down.URL + "/announce", up.URL + "/announce"}}
+	tiers := NewTrackerTiers(metaInfo)
+	for i := 0; i < 3; i++ {
+		if _, err := QueryTracker(context.Background(), metaInfo, tiers); err != nil {
+			t.Fatalf("Error querying: %v", err)
+		}
+	}
+	// The working tracker is promoted by the first announce, and stays first.
+	if downRequests > 1 {
+		t.Errorf("Expected the failed tracker to be tried at most once, got %v", downRequests)
+	}
+	if first := tiers.Tiers()[0][0]; first != up.URL+"/announce" {
+		t.Errorf("Expected %v first, Actual %v", up.URL+"/announce", first)
+	}
+}
+
+func TestTrackerRequestQuery(t *testing.T) {
+	req := TrackerRequest{
+		InfoHash:      InfoHash("\x12\x34\x56\x78\x9a\xbc\xde\xf1\x23\x45\x67\x89\xab\xcd\xef\x12\x34\x56\x78\x9a"),
+		PeerId:        "-GT0100- +/~.aZ09\x00\xff",
+		Port:          "6881",
+		Uploaded:      1,
+		Downloaded:    2,
+		Left:          3,
+		Compact:       true,
+		Event:         EventStarted,
+		Numwant:       80,
+		Key:           "1A2B3C4D",
+		Trackerid:     "a b",
+		Supportcrypto: true,
+		Corrupt:       4,
+	}
+	expected := "info_hash=%124Vx%9A%BC%DE%F1%23Eg%89%AB%CD%EF%124Vx%9A" +
+		"&peer_id=-GT0100-%20%2B%2F~.aZ09%00%FF&port=6881&uploaded=1&downloaded=2&left=3" +
+		"&compact=1&event=started&key=1A2B3C4D&trackerid=a%20b&numwant=80&supportcrypto=1&corrupt=4"
+	if actual := req.Query(); actual != expected {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+}
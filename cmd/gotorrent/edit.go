@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/optimality/gotorrent"
+)
+
+func edit(args []string) error {
+	flags := flag.NewFlagSet("edit", flag.ExitOnError)
+	announce := flags.String("announce", "", "set the announce url")
+	announceList := flags.String("announce-list", "",
+		"set the tracker tiers; trackers within a tier are separated by commas, tiers by |")
+	urlList := flags.String("url-list", "", "set the web seeds, separated by commas")
+	addUrl := flags.String("add-url", "", "add a web seed")
+	comment := flags.String("comment", "", "set the comment")
+	createdBy := flags.String("created-by", "", "set the name of the creating program")
+	creationDate := flags.Int("creation-date", 0, "set the creation date in seconds since the epoch")
+	touch := flags.Bool("touch", false, "set the creation date to now")
+	output := flags.String("o", "", "write the edited torrent to this file instead of replacing the original")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gotorrent edit [flags] <torrent>\n\n"+
+			"Only the flags given are changed; an empty value removes the key.\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path := flags.Arg(0)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	editor, err := gotorrent.LoadEditor(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	// Only apply the flags which were given, so that an empty value can remove a key.
+	var edits []error
+	flags.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "announce":
+			edits = append(edits, editor.SetAnnounce(*announce))
+		case "announce-list":
+			var tiers [][]string
+			for _, tier := range strings.Split(*announceList, "|") {
+				tiers = append(tiers, splitList(tier))
+			}
+			edits = append(edits, editor.SetAnnounceList(tiers))
+		case "url-list":
+			edits = append(edits, editor.SetUrlList(splitList(*urlList)))
+		case "comment":
+			edits = append(edits, editor.SetComment(*comment))
+		case "created-by":
+			edits = append(edits, editor.SetCreatedBy(*createdBy))
+		case "creation-date":
+			edits = append(edits, editor.SetCreationDate(*creationDate))
+		case "touch":
+			if *touch {
+				edits = append(edits, editor.SetCreationDate(int(time.Now().Unix())))
+			}
+		}
+	})
+	if *addUrl != "" {
+		metaInfo, err := editor.MetaInfo()
+		if err != nil {
+			return err
+		}
+		edits = append(edits, editor.SetUrlList(append(metaInfo.Url_List, *addUrl)))
+	}
+	for _, err := range edits {
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := editor.Bytes()
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return ioutil.WriteFile(*output, []byte(b), 0644)
+	}
+	return replaceFile(path, []byte(b))
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	var l []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			l = append(l, entry)
+		}
+	}
+	return l
+}
+
+// replaceFile atomically replaces the file at path with data.
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if stat, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), stat.Mode())
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,51 @@
+// Command gotorrent works with torrent files.
+//
+// Usage:
+//
+//	gotorrent <command> [arguments]
+//
+// The commands are:
+//
+//	edit    change the trackers, web seeds or comment of a torrent
+//	verify  check downloaded data against a torrent's piece hashes
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"edit", "edit [flags] <torrent>", edit},
+	{"verify", "verify [flags] <torrent> <dir>", verify},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gotorrent <command> [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\tgotorrent %v\n", c.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "gotorrent %v: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/optimality/gotorrent"
+)
+
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	workers := flags.Int("workers", 0, "number of pieces to hash concurrently; 0 uses one per CPU")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gotorrent verify [flags] <torrent> <dir>\n\n"+
+			"Checks the torrent's files in dir against its piece hashes.\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	metaInfo, err := gotorrent.LoadMetaInfo(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	mapper := gotorrent.PathMapper{Encoding: metaInfo.Encoding}
+	store := gotorrent.NewFileStoreWithMapper(flags.Arg(1), &metaInfo.Info, mapper)
+	for _, rewrite := range store.Rewrites() {
+		fmt.Printf("renamed  %v\n", rewrite)
+	}
+	v := store.Verify(*workers)
+	for _, file := range v.Files {
+		status := ""
+		switch {
+		case file.Missing:
+			status = "  missing"
+		case file.WrongSize:
+			status = "  wrong size"
+		case file.Mismatched():
+			status = "  mismatched"
+		}
+		fmt.Printf("%6.2f%%  %v%v\n", file.Percent(), file.Path, status)
+	}
+	fmt.Printf("%v of %v pieces verified\n", v.VerifiedPieces(), v.Pieces.Len())
+	if !v.Complete() {
+		return fmt.Errorf("%v pieces failed verification", v.Pieces.Len()-v.VerifiedPieces())
+	}
+	return nil
+}
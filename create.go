@@ -0,0 +1,168 @@
+package gotorrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CreateOptions controls how CreateInfo builds an info dictionary.
+type CreateOptions struct {
+	PieceLength int
+	// AlignFiles inserts BEP 47 padding files so that every file starts on a piece
+	// boundary, which lets other torrents holding the same file share its pieces.
+	AlignFiles bool
+}
+
+// CreateInfo builds the info dictionary for a torrent of the file or directory at path.
+// Executable, hidden and symlinked files are marked with their BEP 47 attributes.
+// Symlinks must point to a file inside the torrent.
+func CreateInfo(path string, options CreateOptions) (*Info, error) {
+	if options.PieceLength <= 0 {
+		return nil, fmt.Errorf("Invalid piece length %v", options.PieceLength)
+	}
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Name: filepath.Base(path), PieceLength: options.PieceLength}
+	hasher := &pieceHasher{pieceLength: options.PieceLength}
+	if !stat.IsDir() {
+		if !stat.Mode().IsRegular() {
+			return nil, fmt.Errorf("Can't create torrent of %v: not a regular file", path)
+		}
+		file, err := hashFile(path, stat, hasher)
+		if err != nil {
+			return nil, err
+		}
+		info.Length, info.Attr, info.Sha1 = file.Length, file.Attr, file.Sha1
+		info.Pieces = hasher.Sum()
+		return info, nil
+	}
+
+	var paths []string
+	err = filepath.Walk(path, func(p string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !stat.IsDir() {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range paths {
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		var file File
+		if stat.Mode()&os.ModeSymlink != 0 {
+			file, err = symlinkFile(path, p)
+		} else {
+			file, err = hashFile(p, stat, hasher)
+		}
+		if err != nil {
+			return nil, err
+		}
+		file.Path = strings.Split(filepath.ToSlash(rel), "/")
+		info.Files = append(info.Files, file)
+
+		if options.AlignFiles && i < len(paths)-1 && file.Length%options.PieceLength != 0 {
+			padding := options.PieceLength - file.Length%options.PieceLength
+			info.Files = append(info.Files, File{
+				Length: padding,
+				Path:   []string{".pad", strconv.Itoa(padding)},
+				Attr:   "p",
+			})
+			hasher.Write(make([]byte, padding))
+		}
+	}
+	info.Pieces = hasher.Sum()
+	return info, nil
+}
+
+// hashFile adds the contents of the file at path to hasher.
+func hashFile(path string, stat os.FileInfo, hasher *pieceHasher) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	fileHash := sha1.New()
+	n, err := io.Copy(io.MultiWriter(hasher, fileHash), f)
+	if err != nil {
+		return File{}, err
+	}
+	file := File{Length: int(n), Sha1: string(fileHash.Sum(nil))}
+	if stat.Mode()&0111 != 0 {
+		file.Attr += "x"
+	}
+	if strings.HasPrefix(stat.Name(), ".") {
+		file.Attr += "h"
+	}
+	return file, nil
+}
+
+// symlinkFile returns the entry for the symlink at path, which must point inside root.
+func symlinkFile(root, path string) (File, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return File{}, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || !filepath.IsLocal(rel) {
+		return File{}, fmt.Errorf("Symlink %v points outside %v", path, root)
+	}
+	return File{Attr: "l", SymlinkPath: strings.Split(filepath.ToSlash(rel), "/")}, nil
+}
+
+// pieceHasher splits the data written to it into pieces and hashes them.
+type pieceHasher struct {
+	pieceLength int
+	buffer      []byte
+	pieces      []byte
+}
+
+func (h *pieceHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		count := h.pieceLength - len(h.buffer)
+		if count > len(p) {
+			count = len(p)
+		}
+		h.buffer = append(h.buffer, p[:count]...)
+		p = p[count:]
+		if len(h.buffer) == h.pieceLength {
+			h.flush()
+		}
+	}
+	return n, nil
+}
+
+func (h *pieceHasher) flush() {
+	hash := sha1.Sum(h.buffer)
+	h.pieces = append(h.pieces, hash[:]...)
+	h.buffer = h.buffer[:0]
+}
+
+// Sum returns the concatenated piece hashes, including the final partial piece.
+func (h *pieceHasher) Sum() string {
+	if len(h.buffer) > 0 {
+		h.flush()
+	}
+	return string(h.pieces)
+}
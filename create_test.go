@@ -0,0 +1,99 @@
+package gotorrent
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreateInfoAlignsFiles(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, filepath.Join(src, "root"), []testFile{
+		{[]string{".hidden"}, "abc"},
+		{[]string{"a.bin"}, "0123456789"},
+		{[]string{"sub", "b.txt"}, "hello"},
+	})
+	if err := os.Chmod(filepath.Join(src, "root", "a.bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("sub", "b.txt"), filepath.Join(src, "root", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := CreateInfo(filepath.Join(src, "root"), CreateOptions{PieceLength: 4, AlignFiles: true})
+	if err != nil {
+		t.Fatalf("Error creating info: %v", err)
+	}
+	helloHash := sha1.Sum([]byte("hello"))
+	expected := []File{
+		{Length: 3, Path: []string{".hidden"}, Attr: "h"},
+		{Length: 1, Path: []string{".pad", "1"}, Attr: "p"},
+		{Length: 10, Path: []string{"a.bin"}, Attr: "x"},
+		{Length: 2, Path: []string{".pad", "2"}, Attr: "p"},
+		{Path: []string{"link"}, Attr: "l", SymlinkPath: []string{"sub", "b.txt"}},
+		{Length: 5, Path: []string{"sub", "b.txt"}, Sha1: string(helloHash[:])},
+	}
+	for i := range info.Files {
+		if i != 5 {
+			info.Files[i].Sha1 = ""
+		}
+	}
+	if !reflect.DeepEqual(expected, info.Files) {
+		t.Errorf("Expected %v, Actual %v", expected, info.Files)
+	}
+	if info.NumPieces() != 6 {
+		t.Errorf("Expected 6 pieces, Actual %v", info.NumPieces())
+	}
+
+	// Copy the torrent piece by piece and check the attributes were applied.
+	source := NewFileStore(src, info)
+	dir := t.TempDir()
+	dest := NewFileStore(dir, info)
+	for i := 0; i < info.NumPieces(); i++ {
+		data, err := source.ReadPiece(i)
+		if err != nil {
+			t.Fatalf("Error reading piece %v: %v", i, err)
+		}
+		if !info.VerifyPiece(i, data) {
+			t.Errorf("Piece %v failed verification", i)
+		}
+		if err := dest.WritePiece(i, data); err != nil {
+			t.Fatalf("Error writing piece %v: %v", i, err)
+		}
+	}
+	if err := dest.CreateFiles(); err != nil {
+		t.Fatalf("Error creating files: %v", err)
+	}
+	checkTestFiles(t, filepath.Join(dir, "root"), []testFile{
+		{[]string{"a.bin"}, "0123456789"},
+		{[]string{"link"}, "hello"},
+	})
+	if _, err := os.Stat(filepath.Join(dir, "root", ".pad")); !os.IsNotExist(err) {
+		t.Errorf("Padding files should not be written: %v", err)
+	}
+	if stat, err := os.Stat(filepath.Join(dir, "root", "a.bin")); err != nil || stat.Mode()&0100 == 0 {
+		t.Errorf("Expected a.bin to be executable: %v", err)
+	}
+}
+
+func TestCreateInfoRejectsEscapingSymlink(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, []testFile{{[]string{"root", "a"}, "a"}, {[]string{"outside"}, "b"}})
+	if err := os.Symlink(filepath.Join("..", "outside"), filepath.Join(src, "root", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateInfo(filepath.Join(src, "root"), CreateOptions{PieceLength: 4}); err == nil {
+		t.Errorf("Expected error for symlink outside the torrent")
+	}
+}
+
+func TestCreateFilesRejectsEscapingSymlink(t *testing.T) {
+	info := &Info{Name: "root", PieceLength: 4, Files: []File{
+		{Path: []string{"link"}, Attr: "l", SymlinkPath: []string{"..", "..", "etc", "passwd"}},
+	}}
+	if err := NewFileStore(t.TempDir(), info).CreateFiles(); err == nil {
+		t.Errorf("Expected error for symlink outside the torrent")
+	}
+}
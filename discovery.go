@@ -0,0 +1,62 @@
+package gotorrent
+
+import (
+	"fmt"
+
+	"github.com/optimality/gotorrent/peer"
+)
+
+// PeerSource identifies a mechanism for discovering peers.
+type PeerSource int
+
+const (
+	PeerSourceTracker         PeerSource = iota // Trackers listed in the metainfo file
+	PeerSourceDHT                               // The distributed hash table, BEP 5
+	PeerSourcePEX                               // Peer exchange, BEP 11
+	PeerSourceLSD                               // Local service discovery, BEP 14
+	PeerSourceTrackerExchange                   // Trackers learned from other peers, BEP 28
+)
+
+func (s PeerSource) String() string {
+	switch s {
+	case PeerSourceTracker:
+		return "tracker"
+	case PeerSourceDHT:
+		return "dht"
+	case PeerSourcePEX:
+		return "pex"
+	case PeerSourceLSD:
+		return "lsd"
+	case PeerSourceTrackerExchange:
+		return "tracker exchange"
+	default:
+		return fmt.Sprintf("PeerSource(%d)", int(s))
+	}
+}
+
+// AllowsPeerSource reports whether peers for the torrent may be discovered through
+// source.  Every peer discovery mechanism must check this before announcing or looking
+// up a torrent, so that private torrents never leak into public discovery.
+func (metaInfo *MetaInfo) AllowsPeerSource(source PeerSource) bool {
+	if metaInfo.IsPrivate() {
+		return source == PeerSourceTracker
+	}
+	return true
+}
+
+// Handshake returns the handshake to send to peers of the torrent.  The DHT bit is only
+// set if the DHT may be used, and the extension protocol bit only if peer or tracker
+// exchange may be, since those are the extensions offered, so private torrents advertise
+// neither.
+func (metaInfo *MetaInfo) Handshake(peerId string) peer.Handshake {
+	var h peer.Handshake
+	copy(h.InfoHash[:], metaInfo.InfoHash)
+	copy(h.PeerId[:], peerId)
+	if metaInfo.AllowsPeerSource(PeerSourceDHT) {
+		h.Reserved.Set(peer.CapabilityDHT)
+	}
+	if metaInfo.AllowsPeerSource(PeerSourcePEX) || metaInfo.AllowsPeerSource(PeerSourceTrackerExchange) {
+		h.Reserved.Set(peer.CapabilityExtension)
+	}
+	return h
+}
@@ -0,0 +1,78 @@
+package gotorrent
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/optimality/gotorrent/bencoding"
+	"github.com/optimality/gotorrent/peer"
+)
+
+func TestAllowsPeerSource(t *testing.T) {
+	sources := []PeerSource{
+		PeerSourceTracker, PeerSourceDHT, PeerSourcePEX, PeerSourceLSD, PeerSourceTrackerExchange,
+	}
+	testFiles := map[string]bool{
+		"sample.torrent":                         true,
+		"ubuntu-14.10-desktop-amd64.iso.torrent": false,
+	}
+	for testFile, private := range testFiles {
+		b, err := ioutil.ReadFile("testData/" + testFile)
+		if err != nil {
+			t.Fatalf("Unable to find testdata.")
+		}
+		var metaInfo MetaInfo
+		if err := bencoding.Unmarshal(string(b), &metaInfo); err != nil {
+			t.Fatalf("Unable to unmarshal %v: %v", testFile, err)
+		}
+		if metaInfo.IsPrivate() != private {
+			t.Errorf("%v: Expected private %v, Actual %v", testFile, private, metaInfo.IsPrivate())
+		}
+		for _, source := range sources {
+			expected := !private || source == PeerSourceTracker
+			if actual := metaInfo.AllowsPeerSource(source); actual != expected {
+				t.Errorf("%v: Expected %v for %v, Actual %v", testFile, expected, source, actual)
+			}
+		}
+	}
+}
+
+func TestPrivateTorrentRefusesPeerSources(t *testing.T) {
+	var metaInfo MetaInfo
+	metaInfo.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	metaInfo.Announce = "http://tracker/announce"
+	for _, private := range []int{0, 1} {
+		metaInfo.Info.Private = private
+		local := metaInfo.Handshake(NewPeerId())
+
+		// The remote peer offers every capability, but only those allowed are agreed on.
+		var remote peer.Handshake
+		remote.PeerId = local.PeerId
+		remote.Reserved.Set(peer.CapabilityDHT)
+		remote.Reserved.Set(peer.CapabilityExtension)
+		c1, c2 := net.Pipe()
+		go peer.Accept(c2, func([20]byte) (peer.Handshake, bool) { return remote, true }, time.Second)
+		result, err := peer.Initiate(c1, local, nil, time.Second)
+		c1.Close()
+		c2.Close()
+		if err != nil {
+			t.Fatalf("Handshake failed: %v", err)
+		}
+		for _, c := range []peer.Capability{peer.CapabilityDHT, peer.CapabilityExtension} {
+			if local.Reserved.Has(c) == (private == 1) || result.Capabilities.Has(c) == (private == 1) {
+				t.Errorf("Private %v: unexpected %v capability %v", private, c, result.Capabilities)
+			}
+		}
+
+		_, err = NewTrackerExchange(&metaInfo, NewTrackerTiers(metaInfo))
+		if (err == nil) == (private == 1) {
+			t.Errorf("Private %v: unexpected tracker exchange error %v", private, err)
+		}
+		// Trackers from the metainfo can always be used.
+		if tiers := NewAnnouncer(&metaInfo, nil).Tiers().Tiers(); len(tiers) != 1 {
+			t.Errorf("Private %v: expected the torrent's tracker, got %v", private, tiers)
+		}
+	}
+}
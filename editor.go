@@ -0,0 +1,115 @@
+package gotorrent
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+// Editor changes the top-level keys of a metainfo file, such as its trackers and web
+// seeds.  The info dict is re-emitted byte-for-byte, so the info hash never changes.
+type Editor struct {
+	dict map[string]bencoding.RawMessage
+}
+
+// NewEditor returns an Editor for the bencoded metainfo file in data.
+func NewEditor(data string) (*Editor, error) {
+	dict := map[string]bencoding.RawMessage{}
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("Metainfo file is not a dict")
+	}
+	if err := bencoding.Unmarshal(data, &dict); err != nil {
+		return nil, fmt.Errorf("Unable to parse metainfo file: %v", err)
+	}
+	if _, ok := dict["info"]; !ok {
+		return nil, fmt.Errorf("Metainfo file has no info dict")
+	}
+	return &Editor{dict}, nil
+}
+
+// LoadEditor reads a metainfo file from r and returns an Editor for it.
+func LoadEditor(r io.Reader) (*Editor, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewEditor(string(b))
+}
+
+// set replaces the value of key, or removes key if value is empty.
+func (e *Editor) set(key string, value interface{}, empty bool) error {
+	if empty {
+		delete(e.dict, key)
+		return nil
+	}
+	marshalled, err := bencoding.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal %v: %v", key, err)
+	}
+	e.dict[key] = bencoding.RawMessage(marshalled)
+	return nil
+}
+
+// SetAnnounce sets the announce url.  An empty url removes it.
+func (e *Editor) SetAnnounce(announce string) error {
+	return e.set("announce", announce, announce == "")
+}
+
+// SetAnnounceList sets the tracker tiers.  Empty tiers are dropped, and an empty list
+// removes the announce-list.
+func (e *Editor) SetAnnounceList(announceList [][]string) error {
+	var tiers [][]string
+	for _, tier := range announceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	return e.set("announce-list", tiers, len(tiers) == 0)
+}
+
+// SetUrlList sets the BEP 19 web seeds.  An empty list removes them.
+func (e *Editor) SetUrlList(urlList []string) error {
+	return e.set("url-list", urlList, len(urlList) == 0)
+}
+
+// SetComment sets the comment.  An empty comment removes it.
+func (e *Editor) SetComment(comment string) error {
+	return e.set("comment", comment, comment == "")
+}
+
+// SetCreatedBy sets the name of the program which created the torrent.  An empty name
+// removes it.
+func (e *Editor) SetCreatedBy(createdBy string) error {
+	return e.set("created by", createdBy, createdBy == "")
+}
+
+// SetCreationDate sets the creation date, in seconds since the epoch.  Zero removes it.
+func (e *Editor) SetCreationDate(creationDate int) error {
+	return e.set("creation date", creationDate, creationDate == 0)
+}
+
+// Bytes returns the edited metainfo file.
+func (e *Editor) Bytes() (string, error) {
+	return bencoding.Marshal(e.dict)
+}
+
+// WriteTo writes the edited metainfo file to w.
+func (e *Editor) WriteTo(w io.Writer) (int64, error) {
+	b, err := e.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.WriteString(w, b)
+	return int64(n), err
+}
+
+// MetaInfo returns the edited metainfo.
+func (e *Editor) MetaInfo() (MetaInfo, error) {
+	b, err := e.Bytes()
+	if err != nil {
+		return MetaInfo{}, err
+	}
+	return parseMetaInfo(b)
+}
@@ -0,0 +1,82 @@
+package gotorrent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+func TestEditorKeepsInfoHash(t *testing.T) {
+	testFiles := []string{
+		"Plan_9_from_Outer_Space_1959_archive.torrent",
+		"ubuntu-14.10-desktop-amd64.iso.torrent",
+		"sample.torrent",
+	}
+	for _, testFile := range testFiles {
+		b, err := ioutil.ReadFile("testData/" + testFile)
+		if err != nil {
+			t.Fatalf("Unable to find testdata.")
+		}
+		var original MetaInfo
+		if err := bencoding.Unmarshal(string(b), &original); err != nil {
+			t.Fatalf("Unable to unmarshal %v: %v", testFile, err)
+		}
+
+		editor, err := LoadEditor(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Unable to load %v: %v", testFile, err)
+		}
+		// With no edits the file is unchanged.
+		unedited, err := editor.Bytes()
+		if err != nil || unedited != string(b) {
+			t.Errorf("%v: Expected unedited file to round trip, err %v", testFile, err)
+		}
+
+		announceList := [][]string{{"udp://a:80/announce", "http://b/announce"}, {}, {"http://c/announce"}}
+		urlList := []string{"http://seed/files/"}
+		editor.SetAnnounce("http://b/announce")
+		editor.SetAnnounceList(announceList)
+		editor.SetUrlList(urlList)
+		editor.SetComment("")
+		editor.SetCreatedBy("gotorrent")
+		editor.SetCreationDate(1500000000)
+
+		var buffer bytes.Buffer
+		if _, err := editor.WriteTo(&buffer); err != nil {
+			t.Fatalf("Unable to write %v: %v", testFile, err)
+		}
+		var edited MetaInfo
+		if err := bencoding.Unmarshal(buffer.String(), &edited); err != nil {
+			t.Fatalf("Unable to unmarshal edited %v: %v", testFile, err)
+		}
+		if edited.InfoHash != original.InfoHash {
+			t.Errorf("%v: Info hash changed", testFile)
+		}
+		if edited.Announce != "http://b/announce" || edited.Comment != "" ||
+			edited.CreatedBy != "gotorrent" || edited.CreationDate != 1500000000 {
+			t.Errorf("%v: Unexpected edited metainfo %v", testFile, edited)
+		}
+		if !reflect.DeepEqual(edited.Announce_List, [][]string{announceList[0], announceList[2]}) {
+			t.Errorf("%v: Unexpected announce-list %v", testFile, edited.Announce_List)
+		}
+		if !reflect.DeepEqual([]string(edited.Url_List), urlList) {
+			t.Errorf("%v: Unexpected url-list %v", testFile, edited.Url_List)
+		}
+		if strings.Contains(buffer.String(), "7:comment") {
+			t.Errorf("%v: Expected comment to be removed", testFile)
+		}
+	}
+}
+
+func TestEditorRejectsInvalidTorrents(t *testing.T) {
+	inputs := []string{"", "i10e", "d8:announce1:ae"}
+	for _, input := range inputs {
+		if _, err := NewEditor(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package gotorrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// InfoHash identifies a torrent.  It holds the raw bytes of the hash of the info dict:
+// 20 bytes of SHA1 for v1 torrents, or 32 bytes of SHA256 for v2 torrents (BEP 52).
+// Use Hex or Base32 to display it; String returns the hex form.
+type InfoHash string
+
+// ParseInfoHash parses an info hash in hex (40 characters for v1, 64 for v2) or base32
+// (32 characters for v1, 52 for v2) form.
+func ParseInfoHash(s string) (InfoHash, error) {
+	var b []byte
+	var err error
+	switch len(s) {
+	case 2 * sha1.Size, 2 * sha256.Size:
+		b, err = hex.DecodeString(s)
+	case 32, 52:
+		b, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(s))
+	default:
+		return "", fmt.Errorf("Invalid info hash %q: wrong length %v", s, len(s))
+	}
+	if err != nil {
+		return "", fmt.Errorf("Invalid info hash %q: %v", s, err)
+	}
+	return InfoHash(b), nil
+}
+
+// IsV1 reports whether h is a 20 byte SHA1 info hash.
+func (h InfoHash) IsV1() bool {
+	return len(h) == sha1.Size
+}
+
+// IsV2 reports whether h is a 32 byte SHA256 info hash.
+func (h InfoHash) IsV2() bool {
+	return len(h) == sha256.Size
+}
+
+// Hex returns h as lower case hex.
+func (h InfoHash) Hex() string {
+	return hex.EncodeToString([]byte(h))
+}
+
+// Base32 returns h as unpadded upper case base32, as used in some magnet links.
+func (h InfoHash) Base32() string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(h))
+}
+
+func (h InfoHash) String() string {
+	return h.Hex()
+}
+
+func (h InfoHash) MarshalText() ([]byte, error) {
+	return []byte(h.Hex()), nil
+}
+
+func (h *InfoHash) UnmarshalText(text []byte) error {
+	parsed, err := ParseInfoHash(string(text))
+	if err != nil {
+		return err
+	}
+	*h = parsed
+	return nil
+}
@@ -0,0 +1,64 @@
+package gotorrent
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestInfoHashFormatting(t *testing.T) {
+	f, err := os.Open("testData/sample.torrent")
+	if err != nil {
+		t.Fatalf("Unable to find testdata.")
+	}
+	defer f.Close()
+	metaInfo, err := LoadMetaInfo(f)
+	if err != nil {
+		t.Fatalf("Unable to load: %v", err)
+	}
+	h := metaInfo.InfoHash
+	if !h.IsV1() || h.IsV2() {
+		t.Errorf("Expected v1 info hash, Actual length %v", len(h))
+	}
+	expectedHex := "d0d14c926e6e99761a2fdcff27b403d96376eff6"
+	expectedBase32 := "2DIUZETON2MXMGRP3T7SPNAD3FRXN37W"
+	if h.Hex() != expectedHex || h.String() != expectedHex {
+		t.Errorf("Expected %v, Actual %v", expectedHex, h.Hex())
+	}
+	if h.Base32() != expectedBase32 {
+		t.Errorf("Expected %v, Actual %v", expectedBase32, h.Base32())
+	}
+	for _, s := range []string{expectedHex, expectedBase32, "2diuzeton2mxmgrp3t7spnad3frxn37w"} {
+		parsed, err := ParseInfoHash(s)
+		if err != nil || parsed != h {
+			t.Errorf("Expected %v from %v, Actual %v, err %v", h, s, parsed, err)
+		}
+	}
+
+	v2, err := ParseInfoHash(expectedHex + "0123456789abcdef01234567")
+	if err != nil || !v2.IsV2() {
+		t.Errorf("Expected v2 info hash, Actual %v, err %v", v2, err)
+	}
+	if parsed, err := ParseInfoHash(v2.Base32()); err != nil || parsed != v2 {
+		t.Errorf("Expected %v, Actual %v, err %v", v2, parsed, err)
+	}
+
+	for _, s := range []string{"", "d0d1", expectedHex[:39] + "g"} {
+		if _, err := ParseInfoHash(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestInfoHashText(t *testing.T) {
+	h, _ := ParseInfoHash("d0d14c926e6e99761a2fdcff27b403d96376eff6")
+	b, err := json.Marshal(map[string]InfoHash{"hash": h})
+	if err != nil || !bytes.Contains(b, []byte(`"d0d14c926e6e99761a2fdcff27b403d96376eff6"`)) {
+		t.Errorf("Unexpected json %s, err %v", b, err)
+	}
+	var decoded map[string]InfoHash
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded["hash"] != h {
+		t.Errorf("Expected %v, Actual %v, err %v", h, decoded["hash"], err)
+	}
+}
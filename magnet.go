@@ -0,0 +1,100 @@
+package gotorrent
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Magnet is a magnet link, which identifies a torrent by its info hash.
+// See BEP 9 and BEP 52 for details.
+type Magnet struct {
+	InfoHash   InfoHash // v1 info hash, from urn:btih
+	InfoHashV2 InfoHash // v2 info hash, from urn:btmh
+	Name       string
+	Trackers   []string
+	WebSeeds   []string
+}
+
+// sha256 multihash prefix, used by urn:btmh.
+const multihashSha256 = "1220"
+
+// ParseMagnet parses a magnet link.  At least one info hash must be present.
+func ParseMagnet(uri string) (Magnet, error) {
+	var m Magnet
+	u, err := url.Parse(uri)
+	if err != nil {
+		return m, fmt.Errorf("Couldn't parse magnet link %v: %v", uri, err)
+	}
+	if u.Scheme != "magnet" {
+		return m, fmt.Errorf("Not a magnet link: %v", uri)
+	}
+	query := u.Query()
+	for _, xt := range query["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			m.InfoHash, err = ParseInfoHash(strings.TrimPrefix(xt, "urn:btih:"))
+			if err == nil && !m.InfoHash.IsV1() {
+				err = fmt.Errorf("Expected v1 info hash in %v", xt)
+			}
+		case strings.HasPrefix(xt, "urn:btmh:"+multihashSha256):
+			m.InfoHashV2, err = ParseInfoHash(strings.TrimPrefix(xt, "urn:btmh:"+multihashSha256))
+			if err == nil && !m.InfoHashV2.IsV2() {
+				err = fmt.Errorf("Expected v2 info hash in %v", xt)
+			}
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+	if m.InfoHash == "" && m.InfoHashV2 == "" {
+		return m, fmt.Errorf("No info hash in magnet link %v", uri)
+	}
+	m.Name = query.Get("dn")
+	m.Trackers = query["tr"]
+	m.WebSeeds = query["ws"]
+	return m, nil
+}
+
+// String returns m as a magnet link.  Info hashes are written in hex.
+func (m Magnet) String() string {
+	var params []string
+	if m.InfoHash != "" {
+		params = append(params, "xt=urn:btih:"+m.InfoHash.Hex())
+	}
+	if m.InfoHashV2 != "" {
+		params = append(params, "xt=urn:btmh:"+multihashSha256+m.InfoHashV2.Hex())
+	}
+	if m.Name != "" {
+		params = append(params, "dn="+url.QueryEscape(m.Name))
+	}
+	for _, tracker := range m.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, webSeed := range m.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(webSeed))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// Magnet returns a magnet link for the torrent.
+func (metaInfo *MetaInfo) Magnet() Magnet {
+	m := Magnet{
+		InfoHash: metaInfo.InfoHash,
+		Name:     metaInfo.Info.Name,
+		WebSeeds: metaInfo.Url_List,
+	}
+	// The announce-list is used in file order rather than shuffled like TrackerTiers, so
+	// that a torrent always gives the same link.
+	for _, tier := range metaInfo.Announce_List {
+		for _, tracker := range tier {
+			if tracker != "" {
+				m.Trackers = append(m.Trackers, tracker)
+			}
+		}
+	}
+	if len(m.Trackers) == 0 && metaInfo.Announce != "" {
+		m.Trackers = []string{metaInfo.Announce}
+	}
+	return m
+}
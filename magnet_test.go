@@ -0,0 +1,77 @@
+package gotorrent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	uri := "magnet:?xt=urn:btih:2DIUZETON2MXMGRP3T7SPNAD3FRXN37W&dn=sample.txt" +
+		"&tr=udp%3A%2F%2Ftracker.openbittorrent.com%3A80&tr=http%3A%2F%2Fa%2Fannounce&ws=http%3A%2F%2Fseed%2F"
+	m, err := ParseMagnet(uri)
+	if err != nil {
+		t.Fatalf("Error parsing %v: %v", uri, err)
+	}
+	if m.InfoHash.Hex() != "d0d14c926e6e99761a2fdcff27b403d96376eff6" || m.Name != "sample.txt" {
+		t.Errorf("Unexpected magnet %+v", m)
+	}
+	if !reflect.DeepEqual(m.Trackers, []string{"udp://tracker.openbittorrent.com:80", "http://a/announce"}) {
+		t.Errorf("Unexpected trackers %v", m.Trackers)
+	}
+	if !reflect.DeepEqual(m.WebSeeds, []string{"http://seed/"}) {
+		t.Errorf("Unexpected web seeds %v", m.WebSeeds)
+	}
+
+	roundTrip, err := ParseMagnet(m.String())
+	if err != nil || !reflect.DeepEqual(m, roundTrip) {
+		t.Errorf("Expected %+v, Actual %+v, err %v", m, roundTrip, err)
+	}
+}
+
+func TestParseMagnetV2(t *testing.T) {
+	hex := "d0d14c926e6e99761a2fdcff27b403d96376eff60123456789abcdef01234567"
+	m, err := ParseMagnet("magnet:?xt=urn:btmh:1220" + hex)
+	if err != nil || m.InfoHashV2.Hex() != hex || m.InfoHash != "" {
+		t.Errorf("Unexpected magnet %+v, err %v", m, err)
+	}
+}
+
+func TestParseMagnetErrors(t *testing.T) {
+	inputs := []string{
+		"http://example.com/",
+		"magnet:?dn=nothing",
+		"magnet:?xt=urn:btih:zz",
+		"magnet:?xt=urn:btih:d0d14c926e6e99761a2fdcff27b403d96376eff60123456789abcdef01234567",
+	}
+	for _, input := range inputs {
+		if _, err := ParseMagnet(input); err == nil {
+			t.Errorf("Expected error parsing %v", input)
+		}
+	}
+}
+
+func TestMetaInfoMagnet(t *testing.T) {
+	metaInfo := &MetaInfo{
+		Announce:      "http://a/announce",
+		Announce_List: [][]string{{"http://a/announce", "http://b/announce", "http://c/announce"}, {"", "http://d/announce"}},
+		InfoHash:      InfoHash("aaaaaaaaaaaaaaaaaaaa"),
+		Info:          Info{Name: "sample.txt"},
+	}
+	expected := []string{"http://a/announce", "http://b/announce", "http://c/announce", "http://d/announce"}
+	// The link is the same each time, with the trackers in file order.
+	link := metaInfo.Magnet().String()
+	for i := 0; i < 10; i++ {
+		m := metaInfo.Magnet()
+		if !reflect.DeepEqual(m.Trackers, expected) {
+			t.Fatalf("Expected %v, Actual %v", expected, m.Trackers)
+		}
+		if m.String() != link {
+			t.Errorf("Expected %v, Actual %v", link, m.String())
+		}
+	}
+
+	metaInfo.Announce_List = nil
+	if m := metaInfo.Magnet(); !reflect.DeepEqual(m.Trackers, []string{"http://a/announce"}) {
+		t.Errorf("Expected the announce url, got %v", m.Trackers)
+	}
+}
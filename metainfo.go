@@ -1,5 +1,16 @@
 package gotorrent
 
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/optimality/gotorrent/bencoding"
+	"github.com/optimality/gotorrent/bitfield"
+)
+
 // A metainfo file (.torrent) gives info about a torrent file.
 // See https://wiki.theory.org/BitTorrentSpecification#Metainfo_File_Structure for details.
 type MetaInfo struct {
@@ -9,15 +20,285 @@ type MetaInfo struct {
 	CreatedBy     string
 	CreationDate  int
 	Encoding      string
-	InfoHash      string
-	Info          struct {
-		Name        string
-		PieceLength int
-		Pieces      string
-		Length      int
-		Files       []struct {
-			Length int
-			Path   []string
+	InfoHash      InfoHash
+	Info          Info
+	// Web seeds, see BEP 19 (url-list) and BEP 17 (httpseeds).
+	Url_List  UrlList
+	Httpseeds UrlList
+	// Extra holds the keys which have no field, so that they survive being written.
+	Extra map[string]bencoding.RawMessage
+
+	// present holds the keys that were read, so that keys with empty values are written
+	// back.
+	present map[string]bool
+	// singleUrl holds the url lists which were read as a single string rather than a list,
+	// so that they are written back the same way.
+	singleUrl map[string]bool
+}
+
+// LoadMetaInfo reads a metainfo file from r.
+func LoadMetaInfo(r io.Reader) (MetaInfo, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return MetaInfo{}, err
+	}
+	return parseMetaInfo(string(b))
+}
+
+func parseMetaInfo(s string) (MetaInfo, error) {
+	var metaInfo MetaInfo
+	if err := bencoding.Unmarshal(s, &metaInfo); err != nil {
+		return metaInfo, fmt.Errorf("Unable to parse metainfo file: %v", err)
+	}
+	if err := metaInfo.recordKeys(s); err != nil {
+		return metaInfo, fmt.Errorf("Unable to parse metainfo file: %v", err)
+	}
+	return metaInfo, nil
+}
+
+// recordKeys records which keys of the metainfo file s, its info dict and its files
+// were present.
+func (metaInfo *MetaInfo) recordKeys(s string) error {
+	var dict map[string]bencoding.RawMessage
+	if err := bencoding.Unmarshal(s, &dict); err != nil {
+		return err
+	}
+	metaInfo.present = dictKeys(dict)
+	metaInfo.singleUrl = map[string]bool{}
+	for _, key := range []string{"url-list", "httpseeds"} {
+		if raw := dict[key]; len(raw) > 0 && raw[0] != 'l' {
+			metaInfo.singleUrl[key] = true
+		}
+	}
+	var info map[string]bencoding.RawMessage
+	if err := bencoding.Unmarshal(string(dict["info"]), &info); err != nil {
+		return err
+	}
+	metaInfo.Info.present = dictKeys(info)
+	if files, ok := info["files"]; ok {
+		var fileDicts []map[string]bencoding.RawMessage
+		if err := bencoding.Unmarshal(string(files), &fileDicts); err != nil {
+			return err
+		}
+		for i := range metaInfo.Info.Files {
+			metaInfo.Info.Files[i].present = dictKeys(fileDicts[i])
+		}
+	}
+	return nil
+}
+
+func dictKeys(dict map[string]bencoding.RawMessage) map[string]bool {
+	keys := make(map[string]bool, len(dict))
+	for key := range dict {
+		keys[key] = true
+	}
+	return keys
+}
+
+// Info is the info dictionary of a metainfo file.  It describes the files in the torrent
+// and the pieces they are split into.
+type Info struct {
+	Name        string
+	PieceLength int
+	Pieces      string
+	Length      int
+	Files       []File
+	// BEP 47 attributes of the file in a single-file torrent.
+	Attr        string
+	SymlinkPath []string
+	Sha1        string
+	// Private is 1 for private torrents, see IsPrivate.
+	Private int
+	// Source identifies where a private torrent was published.  It is typically set by
+	// private trackers so that otherwise identical torrents get different info hashes.
+	Source string
+	// Name_Utf_8 is the UTF-8 version of Name, set by some clients when Name is in
+	// another encoding.
+	Name_Utf_8 string
+	// Collections and Similar relate this torrent to others which may share files with
+	// it (BEP 38).  Collections names groups of torrents, and Similar lists the info
+	// hashes of individual torrents.
+	Collections []string
+	Similar     []InfoHash
+	Extra       map[string]bencoding.RawMessage
+
+	present map[string]bool
+}
+
+// File is an entry in the files list of a multi-file torrent.
+type File struct {
+	Length int
+	Path   []string
+	// Attr holds the BEP 47 attribute flags, see IsPadding and friends.
+	Attr string
+	// SymlinkPath is the target of a symlink, relative to the torrent's root directory.
+	SymlinkPath []string
+	// Sha1 is an optional hash of the file's contents.
+	Sha1 string
+	// Path_Utf_8 is the UTF-8 version of Path, see Info.Name_Utf_8.
+	Path_Utf_8 []string
+	Extra      map[string]bencoding.RawMessage
+
+	present map[string]bool
+}
+
+// IsPadding reports whether f is a padding file.  Padding files are filled with zeros
+// and only exist to align the following file to a piece boundary; they are never written
+// to disk.
+func (f File) IsPadding() bool {
+	return strings.ContainsRune(f.Attr, 'p')
+}
+
+// IsExecutable reports whether f should have its executable bit set.
+func (f File) IsExecutable() bool {
+	return strings.ContainsRune(f.Attr, 'x')
+}
+
+// IsHidden reports whether f is hidden.  This is informational only on unix systems,
+// where hidden files are simply those whose name starts with a dot.
+func (f File) IsHidden() bool {
+	return strings.ContainsRune(f.Attr, 'h')
+}
+
+// IsSymlink reports whether f is a symlink to SymlinkPath.  Symlinks have no data.
+func (f File) IsSymlink() bool {
+	return strings.ContainsRune(f.Attr, 'l')
+}
+
+// UrlList is a list of urls which may be encoded as either a single string or a list of
+// strings, as url-list is in BEP 19.
+type UrlList []string
+
+func (u *UrlList) UnmarshalBencode(s string) error {
+	if len(s) > 0 && s[0] == 'l' {
+		var l []string
+		if err := bencoding.Unmarshal(s, &l); err != nil {
+			return err
+		}
+		*u = l
+		return nil
+	}
+	var url string
+	if err := bencoding.Unmarshal(s, &url); err != nil {
+		return err
+	}
+	*u = nil
+	if url != "" {
+		*u = UrlList{url}
+	}
+	return nil
+}
+
+// IsPrivate reports whether the torrent is private (BEP 27).  Peers for private torrents
+// must only be discovered through the trackers in the metainfo file, never through DHT,
+// peer exchange or local service discovery.
+func (info *Info) IsPrivate() bool {
+	return info.Private == 1
+}
+
+// IsPrivate reports whether the torrent is private, see Info.IsPrivate.
+func (metaInfo *MetaInfo) IsPrivate() bool {
+	return metaInfo.Info.IsPrivate()
+}
+
+// NumPieces returns the number of pieces in the torrent.
+func (info *Info) NumPieces() int {
+	return len(info.Pieces) / sha1.Size
+}
+
+// NewBitfield returns an empty bitfield with a bit for each piece in the torrent.
+func (info *Info) NewBitfield() *bitfield.Bitfield {
+	return bitfield.New(info.NumPieces())
+}
+
+// TotalLength returns the combined length of all files in the torrent.
+func (info *Info) TotalLength() int {
+	if len(info.Files) == 0 {
+		return info.Length
+	}
+	total := 0
+	for _, file := range info.Files {
+		total += file.Length
+	}
+	return total
+}
+
+// PieceSize returns the length of the piece at index.  Only the last piece may be shorter
+// than PieceLength.
+func (info *Info) PieceSize(index int) int {
+	size := info.TotalLength() - index*info.PieceLength
+	if size > info.PieceLength {
+		return info.PieceLength
+	}
+	return size
+}
+
+// PieceHash returns the SHA1 hash of the piece at index.
+func (info *Info) PieceHash(index int) string {
+	return info.Pieces[index*sha1.Size : (index+1)*sha1.Size]
+}
+
+// VerifyPiece reports whether data hashes to the expected hash of the piece at index.
+func (info *Info) VerifyPiece(index int, data []byte) bool {
+	if index < 0 || index >= info.NumPieces() || len(data) != info.PieceSize(index) {
+		return false
+	}
+	hash := sha1.Sum(data)
+	return string(hash[:]) == info.PieceHash(index)
+}
+
+// FileList returns the files in the torrent.  Single-file torrents are returned as a single
+// file whose path is the torrent name; the paths of multi-file torrents are prefixed with
+// the torrent name, which is the name of the directory they are stored in.
+func (info *Info) FileList() []File {
+	if len(info.Files) == 0 {
+		return []File{{
+			Length:      info.Length,
+			Path:        []string{info.Name},
+			Attr:        info.Attr,
+			SymlinkPath: info.SymlinkPath,
+			Sha1:        info.Sha1,
+		}}
+	}
+	files := make([]File, len(info.Files))
+	for i, file := range info.Files {
+		files[i] = file
+		files[i].Path = append([]string{info.Name}, file.Path...)
+	}
+	return files
+}
+
+// FileSpan is the part of a single file covered by a piece.
+type FileSpan struct {
+	File   int // Index into FileList()
+	Offset int // Offset within the file
+	Length int
+}
+
+// PieceSpans returns the parts of each file which make up the piece at index, in order.
+// Pieces in multi-file torrents may span any number of files.
+func (info *Info) PieceSpans(index int) []FileSpan {
+	var spans []FileSpan
+	start := index * info.PieceLength
+	remaining := info.PieceSize(index)
+	fileStart := 0
+	for i, file := range info.FileList() {
+		if remaining <= 0 {
+			break
+		}
+		fileEnd := fileStart + file.Length
+		if start < fileEnd {
+			length := fileEnd - start
+			if length > remaining {
+				length = remaining
+			}
+			if length > 0 {
+				spans = append(spans, FileSpan{i, start - fileStart, length})
+			}
+			start += length
+			remaining -= length
 		}
+		fileStart = fileEnd
 	}
+	return spans
 }
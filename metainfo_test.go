@@ -1,7 +1,9 @@
 package gotorrent
 
 import (
+	"crypto/sha1"
 	"io/ioutil"
+	"reflect"
 	"testing"
 
 	"github.com/optimality/gotorrent/bencoding"
@@ -27,3 +29,122 @@ func TestMetaInfo(t *testing.T) {
 		t.Logf("Loaded from %v, name %v\n", metaInfo.Announce, metaInfo.Info.Name)
 	}
 }
+
+func TestMetaInfoUrlList(t *testing.T) {
+	b, err := ioutil.ReadFile("testData/Plan_9_from_Outer_Space_1959_archive.torrent")
+	if err != nil {
+		t.Fatalf("Unable to find testdata.")
+	}
+	var metaInfo MetaInfo
+	if err := bencoding.Unmarshal(string(b), &metaInfo); err != nil {
+		t.Fatalf("Unable to unmarshal: %v", err)
+	}
+	if len(metaInfo.Url_List) != 3 || metaInfo.Url_List[0] != "https://archive.org/download/" {
+		t.Errorf("Unexpected url-list %v", metaInfo.Url_List)
+	}
+
+	var single MetaInfo
+	input := "d8:url-list17:http://a/file.isoe"
+	if err := bencoding.Unmarshal(input, &single); err != nil {
+		t.Fatalf("Unable to unmarshal %v: %v", input, err)
+	}
+	if len(single.Url_List) != 1 || single.Url_List[0] != "http://a/file.iso" {
+		t.Errorf("Unexpected url-list %v", single.Url_List)
+	}
+}
+
+type testFile struct {
+	path []string
+	data string
+}
+
+// makeTestMetaInfo returns a MetaInfo for the given files.  A single file with an empty
+// path makes a single-file torrent.
+func makeTestMetaInfo(name string, pieceLength int, files []testFile) MetaInfo {
+	var metaInfo MetaInfo
+	infoHash := sha1.Sum([]byte(name))
+	metaInfo.InfoHash = InfoHash(infoHash[:])
+	metaInfo.Info.Name = name
+	metaInfo.Info.PieceLength = pieceLength
+	var all string
+	for _, file := range files {
+		all += file.data
+		if len(file.path) == 0 {
+			metaInfo.Info.Length = len(file.data)
+		} else {
+			metaInfo.Info.Files = append(metaInfo.Info.Files, File{Length: len(file.data), Path: file.path})
+		}
+	}
+	for i := 0; i < len(all); i += pieceLength {
+		end := i + pieceLength
+		if end > len(all) {
+			end = len(all)
+		}
+		hash := sha1.Sum([]byte(all[i:end]))
+		metaInfo.Info.Pieces += string(hash[:])
+	}
+	return metaInfo
+}
+
+func TestPieceSpans(t *testing.T) {
+	metaInfo := makeTestMetaInfo("dir", 8, []testFile{
+		{[]string{"a"}, "0123456789"},
+		{[]string{"b"}, "01"},
+		{[]string{"c", "d"}, "0123456789abc"},
+	})
+	info := &metaInfo.Info
+	if info.NumPieces() != 4 || info.TotalLength() != 25 || info.PieceSize(3) != 1 {
+		t.Errorf("Unexpected layout: %v pieces, length %v, last piece %v",
+			info.NumPieces(), info.TotalLength(), info.PieceSize(3))
+	}
+	expected := [][]FileSpan{
+		{{0, 0, 8}},
+		{{0, 8, 2}, {1, 0, 2}, {2, 0, 4}},
+		{{2, 4, 8}},
+		{{2, 12, 1}},
+	}
+	for i, e := range expected {
+		if actual := info.PieceSpans(i); !reflect.DeepEqual(e, actual) {
+			t.Errorf("Piece %v: Expected %v, Actual %v", i, e, actual)
+		}
+	}
+	if !info.VerifyPiece(1, []byte("89010123")) || info.VerifyPiece(1, []byte("89010124")) {
+		t.Errorf("VerifyPiece gave wrong result")
+	}
+}
+
+func TestMetaInfoFileAttributes(t *testing.T) {
+	input := "d4:infod5:filesl" +
+		"d4:attr1:p6:lengthi3e4:pathl4:.pad1:3ee" +
+		"d4:attr1:l6:lengthi0e4:pathl4:linke12:symlink pathl3:dir1:aee" +
+		"d4:attr2:xh6:lengthi1e4:pathl1:ae4:sha120:aaaaaaaaaaaaaaaaaaaae" +
+		"e4:name4:rootee"
+	var metaInfo MetaInfo
+	if err := bencoding.Unmarshal(input, &metaInfo); err != nil {
+		t.Fatalf("Unable to unmarshal %v: %v", input, err)
+	}
+	files := metaInfo.Info.Files
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files, Actual %v", files)
+	}
+	if !files[0].IsPadding() || files[0].IsSymlink() {
+		t.Errorf("Expected padding file, Actual %v", files[0])
+	}
+	if !files[1].IsSymlink() || !reflect.DeepEqual(files[1].SymlinkPath, []string{"dir", "a"}) {
+		t.Errorf("Expected symlink to dir/a, Actual %v", files[1])
+	}
+	if !files[2].IsExecutable() || !files[2].IsHidden() || files[2].Sha1 != "aaaaaaaaaaaaaaaaaaaa" {
+		t.Errorf("Expected hidden executable with hash, Actual %v", files[2])
+	}
+}
+
+func TestMetaInfoPrivateSource(t *testing.T) {
+	input := "d4:infod4:name1:a7:privatei1e6:source3:abcee"
+	var metaInfo MetaInfo
+	if err := bencoding.Unmarshal(input, &metaInfo); err != nil {
+		t.Fatalf("Unable to unmarshal %v: %v", input, err)
+	}
+	if !metaInfo.IsPrivate() || metaInfo.Info.Source != "abc" {
+		t.Errorf("Expected private torrent from abc, Actual %v", metaInfo.Info)
+	}
+}
@@ -0,0 +1,126 @@
+package gotorrent
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+// bencodeDict holds the keys of a dict being written.  Marshal sorts the keys, as the
+// spec requires.
+type bencodeDict map[string]interface{}
+
+// set adds key to the dict unless the value is empty.
+func (d bencodeDict) set(key string, value interface{}, empty bool) {
+	if !empty {
+		d[key] = value
+	}
+}
+
+// setRead adds key to the dict unless the value is empty and the key wasn't present in
+// the dict that was read, so that explicit empty values, which change the info hash,
+// survive being written.
+func (d bencodeDict) setRead(present map[string]bool, key string, value interface{}, empty bool) {
+	d.set(key, value, empty && !present[key])
+}
+
+// setExtra adds the keys from extra which are not already in the dict.
+func (d bencodeDict) setExtra(extra map[string]bencoding.RawMessage) {
+	for key, value := range extra {
+		if _, ok := d[key]; !ok {
+			d[key] = value
+		}
+	}
+}
+
+func (file *File) bencodeDict() bencodeDict {
+	d := bencodeDict{
+		"length": file.Length,
+		"path":   file.Path,
+	}
+	d.setRead(file.present, "attr", file.Attr, file.Attr == "")
+	d.setRead(file.present, "symlink path", file.SymlinkPath, len(file.SymlinkPath) == 0)
+	d.setRead(file.present, "sha1", file.Sha1, file.Sha1 == "")
+	d.setRead(file.present, "path.utf-8", file.Path_Utf_8, len(file.Path_Utf_8) == 0)
+	d.setExtra(file.Extra)
+	return d
+}
+
+func (info *Info) bencodeDict() bencodeDict {
+	d := bencodeDict{
+		"name":         info.Name,
+		"piece length": info.PieceLength,
+		"pieces":       info.Pieces,
+	}
+	if len(info.Files) == 0 {
+		d["length"] = info.Length
+	} else {
+		files := make([]bencodeDict, len(info.Files))
+		for i := range info.Files {
+			files[i] = info.Files[i].bencodeDict()
+		}
+		d["files"] = files
+	}
+	d.setRead(info.present, "attr", info.Attr, info.Attr == "")
+	d.setRead(info.present, "symlink path", info.SymlinkPath, len(info.SymlinkPath) == 0)
+	d.setRead(info.present, "sha1", info.Sha1, info.Sha1 == "")
+	d.setRead(info.present, "private", info.Private, info.Private == 0)
+	d.setRead(info.present, "source", info.Source, info.Source == "")
+	d.setRead(info.present, "name.utf-8", info.Name_Utf_8, info.Name_Utf_8 == "")
+	d.setRead(info.present, "collections", info.Collections, len(info.Collections) == 0)
+	d.setRead(info.present, "similar", info.Similar, len(info.Similar) == 0)
+	d.setExtra(info.Extra)
+	return d
+}
+
+// Bencode returns the info dict in its canonical bencoded form, whose SHA1 is the info
+// hash.
+func (info *Info) Bencode() (string, error) {
+	return bencoding.Marshal(info.bencodeDict())
+}
+
+// Bytes returns the metainfo file.  Keys are sorted and empty optional keys are omitted,
+// as the spec requires, unless they were present in the file that was read.  Keys which
+// were read but have no field are written back unchanged.
+// The info dict is written from the Info field, so the info hash only stays the same if
+// the original info dict was canonically encoded; use an Editor to change the other keys
+// of a torrent which may not be.
+func (metaInfo *MetaInfo) Bytes() (string, error) {
+	if metaInfo.Info.Name == "" || metaInfo.Info.PieceLength <= 0 || metaInfo.Info.Pieces == "" {
+		return "", fmt.Errorf("Info dict is missing name, piece length or pieces")
+	}
+	d := bencodeDict{"info": metaInfo.Info.bencodeDict()}
+	d.setRead(metaInfo.present, "announce", metaInfo.Announce, metaInfo.Announce == "")
+	d.setRead(metaInfo.present, "announce-list", metaInfo.Announce_List, len(metaInfo.Announce_List) == 0)
+	d.setRead(metaInfo.present, "comment", metaInfo.Comment, metaInfo.Comment == "")
+	d.setRead(metaInfo.present, "created by", metaInfo.CreatedBy, metaInfo.CreatedBy == "")
+	d.setRead(metaInfo.present, "creation date", metaInfo.CreationDate, metaInfo.CreationDate == 0)
+	d.setRead(metaInfo.present, "encoding", metaInfo.Encoding, metaInfo.Encoding == "")
+	d.setRead(metaInfo.present, "url-list", metaInfo.urlListValue("url-list", metaInfo.Url_List), len(metaInfo.Url_List) == 0)
+	d.setRead(metaInfo.present, "httpseeds", metaInfo.urlListValue("httpseeds", metaInfo.Httpseeds), len(metaInfo.Httpseeds) == 0)
+	d.setExtra(metaInfo.Extra)
+	return bencoding.Marshal(d)
+}
+
+// urlListValue returns the value to write for the url list under key: a single string if
+// it was read as one and still has at most one url, and a list otherwise.
+func (metaInfo *MetaInfo) urlListValue(key string, urls UrlList) interface{} {
+	if metaInfo.singleUrl[key] && len(urls) <= 1 {
+		if len(urls) == 0 {
+			return ""
+		}
+		return urls[0]
+	}
+	return []string(urls)
+}
+
+// WriteTo writes the metainfo file to w, see Bytes.
+func (metaInfo *MetaInfo) WriteTo(w io.Writer) (int64, error) {
+	b, err := metaInfo.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.WriteString(w, b)
+	return int64(n), err
+}
@@ -0,0 +1,127 @@
+package gotorrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMetaInfoWriteToRoundTrip(t *testing.T) {
+	testFiles := []string{
+		"Plan_9_from_Outer_Space_1959_archive.torrent",
+		"ubuntu-14.10-desktop-amd64.iso.torrent",
+		"sample.torrent",
+	}
+	for _, testFile := range testFiles {
+		b, err := ioutil.ReadFile("testData/" + testFile)
+		if err != nil {
+			t.Fatalf("Unable to find testdata.")
+		}
+		metaInfo, err := LoadMetaInfo(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Unable to load %v: %v", testFile, err)
+		}
+		var buffer bytes.Buffer
+		n, err := metaInfo.WriteTo(&buffer)
+		if err != nil {
+			t.Errorf("Unable to write %v: %v", testFile, err)
+			continue
+		}
+		if n != int64(len(b)) || !bytes.Equal(b, buffer.Bytes()) {
+			t.Errorf("%v: Round trip changed the file, wrote %v bytes, expected %v", testFile, n, len(b))
+		}
+		info, err := metaInfo.Info.Bencode()
+		if err != nil {
+			t.Errorf("Unable to encode info for %v: %v", testFile, err)
+		}
+		if hash := sha1.Sum([]byte(info)); InfoHash(hash[:]) != metaInfo.InfoHash {
+			t.Errorf("%v: Expected info hash %v, Actual %x", testFile, metaInfo.InfoHash, hash)
+		}
+	}
+}
+
+func TestMetaInfoBytesOmitsEmptyKeys(t *testing.T) {
+	metaInfo := makeTestMetaInfo("a.txt", 4, []testFile{{nil, "hello"}})
+	metaInfo.Announce = "http://a/announce"
+	b, err := metaInfo.Bytes()
+	if err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	expected := "d8:announce17:http://a/announce4:infod6:lengthi5e4:name5:a.txt" +
+		"12:piece lengthi4e6:pieces40:" + metaInfo.Info.Pieces + "ee"
+	if b != expected {
+		t.Errorf("Expected %q, Actual %q", expected, b)
+	}
+	for _, key := range []string{"comment", "url-list", "announce-list", "private"} {
+		if strings.Contains(b, key) {
+			t.Errorf("Expected empty key %v to be omitted", key)
+		}
+	}
+
+	var empty MetaInfo
+	if _, err := empty.Bytes(); err == nil {
+		t.Errorf("Expected error writing metainfo without info")
+	}
+}
+
+func TestMetaInfoWriteKeepsEmptyKeys(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+	input := "d7:comment0:13:creation datei0e4:infod5:filesld4:attr0:6:lengthi5e4:pathl5:a.txtee" +
+		"d6:lengthi0e4:pathl5:b.txte4:sha10:ee4:name5:files12:piece lengthi4e6:pieces20:" + pieces +
+		"7:privatei0e6:source0:ee"
+	metaInfo, err := LoadMetaInfo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unable to load: %v", err)
+	}
+	b, err := metaInfo.Bytes()
+	if err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	if b != input {
+		t.Errorf("Expected %q, Actual %q", input, b)
+	}
+	info, _ := metaInfo.Info.Bencode()
+	if hash := sha1.Sum([]byte(info)); InfoHash(hash[:]) != metaInfo.InfoHash {
+		t.Errorf("Expected info hash %v, Actual %x", metaInfo.InfoHash, hash)
+	}
+}
+
+func TestMetaInfoWriteKeepsExtraKey(t *testing.T) {
+	input := "d5:extra3:top4:infod5:extrad1:ai1ee6:lengthi5e4:name5:a.txt12:piece lengthi4e" +
+		"6:pieces20:" + strings.Repeat("a", 20) + "ee"
+	metaInfo, err := LoadMetaInfo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unable to load: %v", err)
+	}
+	if b, err := metaInfo.Bytes(); err != nil || b != input {
+		t.Errorf("Expected %q, Actual %q, %v", input, b, err)
+	}
+}
+
+func TestMetaInfoWriteKeepsUrlListForm(t *testing.T) {
+	info := "4:infod6:lengthi5e4:name5:a.txt12:piece lengthi4e6:pieces20:" + strings.Repeat("a", 20) + "e"
+	inputs := []string{
+		"d9:httpseedsl13:http://b/seede" + info + "8:url-list9:http://a/e",
+		"d9:httpseeds0:" + info + "8:url-listl9:http://a/ee",
+		"d" + info + "8:url-list0:e",
+	}
+	for _, input := range inputs {
+		metaInfo, err := LoadMetaInfo(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Unable to load %q: %v", input, err)
+		}
+		if b, err := metaInfo.Bytes(); err != nil || b != input {
+			t.Errorf("Expected %q, Actual %q, %v", input, b, err)
+		}
+	}
+
+	// A single url which gains another is written as a list.
+	metaInfo, _ := LoadMetaInfo(strings.NewReader(inputs[0]))
+	metaInfo.Url_List = append(metaInfo.Url_List, "http://c/")
+	expected := "d9:httpseedsl13:http://b/seede" + info + "8:url-listl9:http://a/9:http://c/ee"
+	if b, err := metaInfo.Bytes(); err != nil || b != expected {
+		t.Errorf("Expected %q, Actual %q, %v", expected, b, err)
+	}
+}
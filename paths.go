@@ -0,0 +1,226 @@
+package gotorrent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// PathRewrite records the changes made to the path of one file to make it safe to create
+// on disk.
+type PathRewrite struct {
+	File     int    // Index into FileList()
+	Original string // Slash separated path from the torrent
+	Path     string // Slash separated path used on disk
+	Reasons  []string
+}
+
+func (r PathRewrite) String() string {
+	return fmt.Sprintf("%q -> %q (%v)", r.Original, r.Path, strings.Join(r.Reasons, ", "))
+}
+
+// PathMapper maps the paths in a torrent, which come from untrusted input, to relative
+// paths which are safe to create on any common filesystem.  Components which could
+// escape the download directory ("..", absolute paths and drive letters) are rewritten,
+// as are empty components, reserved characters and names, and names which are too long.
+// Paths which would collide with an earlier file, ignoring case, are made unique.
+type PathMapper struct {
+	// Encoding is the character encoding of paths which are not valid UTF-8, typically
+	// MetaInfo.Encoding.  Only Latin-1 style encodings can be decoded; other invalid
+	// paths have their invalid bytes replaced.
+	Encoding string
+	// MaxNameLength is the maximum length in bytes of each path component.  If zero,
+	// 255 is used.
+	MaxNameLength int
+}
+
+const (
+	pathEntryFile = iota + 1
+	pathEntryDir
+)
+
+// Map returns the safe relative path for each file in info.FileList(), along with a
+// report of every path which had to be changed.  The UTF-8 paths from name.utf-8 and
+// path.utf-8 are preferred when present.
+func (m PathMapper) Map(info *Info) (paths [][]string, rewrites []PathRewrite) {
+	used := map[string]int{}
+	dirRenames := map[string]string{}
+	for i, file := range info.FileList() {
+		original := file.Path
+		name := info.Name
+		if info.Name_Utf_8 != "" {
+			name = info.Name_Utf_8
+		}
+		components := append([]string{name}, file.Path[1:]...)
+		if len(info.Files) > 0 && len(info.Files[i].Path_Utf_8) > 0 {
+			components = append([]string{name}, info.Files[i].Path_Utf_8...)
+		}
+
+		var reasons []string
+		var sanitised []string
+		for _, component := range components {
+			s, reason := m.sanitiseComponent(component)
+			if reason != "" {
+				reasons = append(reasons, reason)
+			}
+			if s != "" {
+				sanitised = append(sanitised, s)
+			}
+		}
+		if len(sanitised) == 0 {
+			sanitised = []string{"_"}
+		}
+
+		// Resolve directories, renaming any which collide with a file.
+		var mapped []string
+		parent := ""
+		for _, component := range sanitised[:len(sanitised)-1] {
+			key := pathKey(parent, component)
+			if renamed, ok := dirRenames[key]; ok {
+				component = renamed
+			} else if used[key] == pathEntryFile {
+				renamed := m.unique(parent, component, used)
+				dirRenames[key] = renamed
+				reasons = append(reasons, fmt.Sprintf("directory %q collides with a file", component))
+				component = renamed
+			}
+			mapped = append(mapped, component)
+			parent = pathKey(parent, component)
+			if used[parent] == 0 {
+				used[parent] = pathEntryDir
+			}
+		}
+		last := sanitised[len(sanitised)-1]
+		if used[pathKey(parent, last)] != 0 {
+			reasons = append(reasons, fmt.Sprintf("%q collides with an earlier path", last))
+			last = m.unique(parent, last, used)
+		}
+		mapped = append(mapped, last)
+		used[pathKey(parent, last)] = pathEntryFile
+
+		paths = append(paths, mapped)
+		if len(reasons) > 0 || path.Join(mapped...) != path.Join(original...) {
+			if len(reasons) == 0 {
+				reasons = []string{"using UTF-8 path"}
+			}
+			rewrites = append(rewrites, PathRewrite{
+				File:     i,
+				Original: path.Join(original...),
+				Path:     path.Join(mapped...),
+				Reasons:  reasons,
+			})
+		}
+	}
+	return paths, rewrites
+}
+
+// pathKey returns the key used to detect collisions between paths.  Case is ignored,
+// since many filesystems are case-insensitive.
+func pathKey(parent, component string) string {
+	return parent + "/" + strings.ToLower(component)
+}
+
+func (m PathMapper) maxNameLength() int {
+	if m.MaxNameLength > 0 {
+		return m.MaxNameLength
+	}
+	return 255
+}
+
+// unique returns a variant of name which is not yet used in parent.
+func (m PathMapper) unique(parent, name string, used map[string]int) string {
+	ext := path.Ext(name)
+	if len(ext) > m.maxNameLength()/2 || ext == name {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		candidate := truncateUtf8(stem, m.maxNameLength()-len(suffix)-len(ext)) + suffix + ext
+		if used[pathKey(parent, candidate)] == 0 {
+			return candidate
+		}
+	}
+}
+
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// sanitiseComponent returns a safe version of a single path component, or "" if the
+// component should be dropped, along with the reason for any change.
+func (m PathMapper) sanitiseComponent(component string) (string, string) {
+	var reasons []string
+	if !utf8.ValidString(component) {
+		component = m.decode(component)
+		reasons = append(reasons, "invalid UTF-8")
+	}
+	switch component {
+	case "", ".":
+		return "", fmt.Sprintf("dropped component %q", component)
+	case "..":
+		return "_", "parent directory component"
+	}
+
+	replaced := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, component)
+	if replaced != component {
+		reasons = append(reasons, "reserved characters")
+		component = replaced
+	}
+	if trimmed := strings.TrimRight(component, " ."); trimmed != component {
+		reasons = append(reasons, "trailing spaces or dots")
+		component = trimmed + "_"
+	}
+	base := strings.ToLower(component)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[base] {
+		reasons = append(reasons, "reserved name")
+		component = "_" + component
+	}
+	if len(component) > m.maxNameLength() {
+		reasons = append(reasons, "name too long")
+		ext := path.Ext(component)
+		if len(ext) > m.maxNameLength()/2 {
+			ext = ""
+		}
+		component = truncateUtf8(strings.TrimSuffix(component, ext), m.maxNameLength()-len(ext)) + ext
+	}
+	return component, strings.Join(reasons, ", ")
+}
+
+// decode converts s from m.Encoding to UTF-8.
+func (m PathMapper) decode(s string) string {
+	switch strings.ToLower(strings.Replace(m.Encoding, "_", "-", -1)) {
+	case "iso-8859-1", "latin1", "latin-1", "windows-1252", "cp1252":
+		runes := make([]rune, len(s))
+		for i := 0; i < len(s); i++ {
+			runes[i] = rune(s[i])
+		}
+		return string(runes)
+	default:
+		return strings.ToValidUTF8(s, "_")
+	}
+}
+
+// truncateUtf8 truncates s to at most n bytes without splitting a UTF-8 sequence.
+func truncateUtf8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,87 @@
+package gotorrent
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+func TestPathMapper(t *testing.T) {
+	info := &Info{Name: "root", Files: []File{
+		{Path: []string{"..", "..", "etc", "passwd"}},
+		{Path: []string{"/abs", "C:", "ok.txt"}},
+		{Path: []string{"", ".", "a", "", "b.txt"}},
+		{Path: []string{"A", "B.TXT"}},
+		{Path: []string{"con.txt"}},
+		{Path: []string{"what?<>|.txt"}},
+		{Path: []string{"trailing. "}},
+		{Path: []string{"x"}},
+		{Path: []string{"x", "y"}},
+		{Path: []string{"X", "z"}},
+		{Path: []string{strings.Repeat("n", 300) + ".txt"}},
+		{Path: []string{strings.Repeat("n", 300) + ".txt"}},
+		{Path: []string{"caf\xe9"}},
+		{Path: []string{"bad"}, Path_Utf_8: []string{"good"}},
+	}}
+	long := strings.Repeat("n", 251) + ".txt"
+	long1 := strings.Repeat("n", 247) + " (1).txt"
+	expected := [][]string{
+		{"root", "_", "_", "etc", "passwd"},
+		{"root", "_abs", "C_", "ok.txt"},
+		{"root", "a", "b.txt"},
+		{"root", "A", "B (1).TXT"},
+		{"root", "_con.txt"},
+		{"root", "what____.txt"},
+		{"root", "trailing_"},
+		{"root", "x"},
+		{"root", "x (1)", "y"},
+		{"root", "x (1)", "z"},
+		{"root", long},
+		{"root", long1},
+		{"root", "café"},
+		{"root", "good"},
+	}
+	paths, rewrites := PathMapper{Encoding: "ISO-8859-1"}.Map(info)
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected %q, Actual %q", expected, paths)
+	}
+	// Only x/y and x/z are unchanged.
+	if len(rewrites) != len(expected)-1 {
+		t.Errorf("Expected %v rewrites, Actual %v", len(expected)-1, rewrites)
+	}
+	for _, rewrite := range rewrites {
+		if rewrite.File == 7 {
+			t.Errorf("Unexpected rewrite %v", rewrite)
+		}
+		if len(rewrite.Reasons) == 0 {
+			t.Errorf("Rewrite %v has no reason", rewrite)
+		}
+	}
+}
+
+func TestPathMapperSingleFile(t *testing.T) {
+	info := &Info{Name: "..", Length: 10}
+	paths, rewrites := PathMapper{}.Map(info)
+	if !reflect.DeepEqual([][]string{{"_"}}, paths) || len(rewrites) != 1 {
+		t.Errorf("Unexpected paths %q, rewrites %v", paths, rewrites)
+	}
+	paths, rewrites = PathMapper{}.Map(&Info{Name: "a.iso", Length: 10})
+	if !reflect.DeepEqual([][]string{{"a.iso"}}, paths) || len(rewrites) != 0 {
+		t.Errorf("Unexpected paths %q, rewrites %v", paths, rewrites)
+	}
+}
+
+func TestPathMapperDecodesUtf8Keys(t *testing.T) {
+	input := "d4:infod5:filesld6:lengthi1e4:pathl1:xe10:path.utf-8l5:\xc3\xa9t\xc3\xa9ee" +
+		"e4:name1:n10:name.utf-82:\xc3\xb1ee"
+	var metaInfo MetaInfo
+	if err := bencoding.Unmarshal(input, &metaInfo); err != nil {
+		t.Fatalf("Unable to unmarshal %q: %v", input, err)
+	}
+	paths, _ := PathMapper{}.Map(&metaInfo.Info)
+	if expected := [][]string{{"ñ", "été"}}; !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected %q, Actual %q", expected, paths)
+	}
+}
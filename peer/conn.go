@@ -0,0 +1,476 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/optimality/gotorrent/bitfield"
+)
+
+const (
+	// DefaultMinPipeline and DefaultMaxPipeline bound the number of outstanding requests.
+	DefaultMinPipeline = 2
+	DefaultMaxPipeline = 128
+	// DefaultRequestQueueTime is how long the outstanding requests should take to arrive.
+	DefaultRequestQueueTime = 3 * time.Second
+	// DefaultRequestTimeout is how long a request may be outstanding before it's cancelled.
+	DefaultRequestTimeout = time.Minute
+	// DefaultSnubTimeout is how long a peer that unchoked us may go without sending a
+	// block before it's considered to be snubbing us.
+	DefaultSnubTimeout = time.Minute
+
+	// initialPipeline is the pipeline depth before any throughput has been measured.
+	initialPipeline = 4
+	// rateWindow is the period over which throughput is measured.
+	rateWindow = time.Second
+)
+
+// BlockRequest identifies a block of a piece.
+type BlockRequest struct {
+	Index  uint32
+	Begin  uint32
+	Length uint32
+}
+
+// PeerConn is a connection to a peer after the handshake.  It tracks the choke and
+// interest state in both directions, the pieces the peer has, and the blocks we have
+// requested from it.  The number of outstanding requests is tuned to the measured
+// throughput, so that the requests in flight take about RequestQueueTime to arrive.
+//
+// Messages are read with ReadMessage, which must only be called from one goroutine.
+// Messages sent are buffered until Flush, so that several go out together.  The other
+// methods may be called concurrently.
+type PeerConn struct {
+	// MinPipeline and MaxPipeline bound the number of outstanding requests.
+	MinPipeline int
+	MaxPipeline int
+	// RequestQueueTime is how long the outstanding requests should take to arrive.
+	RequestQueueTime time.Duration
+	// RequestTimeout is how long a request may be outstanding before CheckTimeouts
+	// cancels it.
+	RequestTimeout time.Duration
+	// SnubTimeout is how long a peer that has unchoked us may go without sending a
+	// requested block before it is snubbing us.
+	SnubTimeout time.Duration
+	// Dropped, if set, is called with requests that will no longer be answered, because
+	// the peer choked us or rejected them, or the requests timed out, so they can be
+	// requested elsewhere.  With the fast extension, a choke doesn't drop requests, since
+	// the peer rejects each one it won't answer (BEP 6).
+	Dropped func(requests []BlockRequest)
+
+	// Peer describes the peer, from its handshake.
+	Peer *HandshakeResult
+
+	conn      net.Conn
+	reader    *Reader
+	writer    *Writer
+	numPieces int
+	now       func() time.Time
+
+	// sendMu is held from a change to the state until the message announcing it has been
+	// written, so that the messages go out in the order of the changes.  It is taken
+	// before mu.
+	sendMu         sync.Mutex
+	mu             sync.Mutex
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+	remotePieces   *bitfield.Bitfield
+	gotMessage     bool
+	requests       map[BlockRequest]time.Time
+	pipeline       int
+	snubbed        bool
+	// lastProgress is when a block last arrived, or requests were last made with none
+	// outstanding.
+	lastProgress time.Time
+	rate         float64
+	windowStart  time.Time
+	windowBytes  int
+}
+
+// NewPeerConn returns a PeerConn for conn, on which the handshake in peer has completed,
+// for a torrent with numPieces pieces.  Both sides start out choking and not interested.
+func NewPeerConn(conn net.Conn, peer *HandshakeResult, numPieces int) *PeerConn {
+	return &PeerConn{
+		MinPipeline:      DefaultMinPipeline,
+		MaxPipeline:      DefaultMaxPipeline,
+		RequestQueueTime: DefaultRequestQueueTime,
+		RequestTimeout:   DefaultRequestTimeout,
+		SnubTimeout:      DefaultSnubTimeout,
+		Peer:             peer,
+		conn:             conn,
+		reader:           NewReader(conn),
+		writer:           NewWriter(conn),
+		numPieces:        numPieces,
+		now:              time.Now,
+		amChoking:        true,
+		peerChoking:      true,
+		remotePieces:     bitfield.New(numPieces),
+		requests:         map[BlockRequest]time.Time{},
+		pipeline:         initialPipeline,
+	}
+}
+
+// Close closes the connection, dropping any outstanding requests.
+func (c *PeerConn) Close() error {
+	c.mu.Lock()
+	dropped := c.clearRequests()
+	c.mu.Unlock()
+	c.drop(dropped)
+	return c.conn.Close()
+}
+
+// AmChoking reports whether we are choking the peer.
+func (c *PeerConn) AmChoking() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.amChoking
+}
+
+// AmInterested reports whether we are interested in the peer's pieces.
+func (c *PeerConn) AmInterested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.amInterested
+}
+
+// PeerChoking reports whether the peer is choking us.
+func (c *PeerConn) PeerChoking() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerChoking
+}
+
+// PeerInterested reports whether the peer is interested in our pieces.
+func (c *PeerConn) PeerInterested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerInterested
+}
+
+// RemoteHas reports whether the peer has piece index.
+func (c *PeerConn) RemoteHas(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remotePieces.Get(index)
+}
+
+// RemotePieces returns a copy of the pieces the peer has.
+func (c *PeerConn) RemotePieces() *bitfield.Bitfield {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remotePieces.Clone()
+}
+
+// Interesting reports whether the peer has any pieces that aren't in have, in which case
+// we should be interested.
+func (c *PeerConn) Interesting(have *bitfield.Bitfield) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remotePieces.AndNot(have).NextSet(0) >= 0
+}
+
+// Snubbed reports whether the peer has unchoked us but stopped sending blocks.
+func (c *PeerConn) Snubbed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snubbed
+}
+
+// Pipeline returns the number of requests that may be outstanding.
+func (c *PeerConn) Pipeline() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pipelineDepth()
+}
+
+// pipelineDepth returns the pipeline depth within the configured bounds.  c.mu must be
+// held.
+func (c *PeerConn) pipelineDepth() int {
+	if c.snubbed {
+		return 1
+	}
+	depth := c.pipeline
+	if depth > c.MaxPipeline {
+		depth = c.MaxPipeline
+	}
+	if depth < c.MinPipeline {
+		depth = c.MinPipeline
+	}
+	return depth
+}
+
+// Outstanding returns the requests that haven't been answered yet.
+func (c *PeerConn) Outstanding() []BlockRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var requests []BlockRequest
+	for r := range c.requests {
+		requests = append(requests, r)
+	}
+	return requests
+}
+
+// Choke chokes the peer.
+func (c *PeerConn) Choke() error {
+	return c.setState(&c.amChoking, true, Choke)
+}
+
+// Unchoke unchokes the peer.
+func (c *PeerConn) Unchoke() error {
+	return c.setState(&c.amChoking, false, Unchoke)
+}
+
+// Interested tells the peer we are interested in its pieces.
+func (c *PeerConn) Interested() error {
+	return c.setState(&c.amInterested, true, Interested)
+}
+
+// NotInterested tells the peer we are no longer interested in its pieces.
+func (c *PeerConn) NotInterested() error {
+	return c.setState(&c.amInterested, false, NotInterested)
+}
+
+// setState sets *state to value, and sends id if it changed.
+func (c *PeerConn) setState(state *bool, value bool, id MessageId) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	changed := *state != value
+	*state = value
+	c.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return c.writer.WriteMessage(Message{Id: id})
+}
+
+// Send sends m, which should not change the choke or interest state; use Choke, Unchoke,
+// Interested and NotInterested instead.
+func (c *PeerConn) Send(m Message) error {
+	return c.writer.WriteMessage(m)
+}
+
+// Flush sends any buffered messages.
+func (c *PeerConn) Flush() error {
+	return c.writer.Flush()
+}
+
+// CanRequest returns the number of further requests the pipeline allows.  It is zero if
+// the peer is choking us.
+func (c *PeerConn) CanRequest() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peerChoking {
+		return 0
+	}
+	if free := c.pipelineDepth() - len(c.requests); free > 0 {
+		return free
+	}
+	return 0
+}
+
+// Request requests a block from the peer.  It returns false without sending anything if
+// the peer is choking us, doesn't have the piece, the pipeline is full, or the block has
+// already been requested.
+func (c *PeerConn) Request(r BlockRequest) (bool, error) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	if c.peerChoking || !c.remotePieces.Get(int(r.Index)) ||
+		len(c.requests) >= c.pipelineDepth() {
+		c.mu.Unlock()
+		return false, nil
+	}
+	if _, ok := c.requests[r]; ok {
+		c.mu.Unlock()
+		return false, nil
+	}
+	now := c.now()
+	if len(c.requests) == 0 {
+		c.lastProgress = now
+	}
+	c.requests[r] = now
+	c.mu.Unlock()
+	return true, c.writer.WriteMessage(Message{Id: Request, Index: r.Index, Begin: r.Begin, Length: r.Length})
+}
+
+// Cancel cancels an outstanding request.
+func (c *PeerConn) Cancel(r BlockRequest) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.mu.Lock()
+	_, ok := c.requests[r]
+	delete(c.requests, r)
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return c.writer.WriteMessage(Message{Id: Cancel, Index: r.Index, Begin: r.Begin, Length: r.Length})
+}
+
+// CheckTimeouts cancels requests that have been outstanding for longer than
+// RequestTimeout, and notices if the peer is snubbing us.  It should be called
+// periodically, for instance every few seconds.
+func (c *PeerConn) CheckTimeouts() error {
+	c.sendMu.Lock()
+	c.mu.Lock()
+	now := c.now()
+	var expired []BlockRequest
+	for r, requested := range c.requests {
+		if now.Sub(requested) >= c.RequestTimeout {
+			expired = append(expired, r)
+			delete(c.requests, r)
+		}
+	}
+	if !c.peerChoking && (len(c.requests) > 0 || len(expired) > 0) &&
+		now.Sub(c.lastProgress) >= c.SnubTimeout {
+		c.snubbed = true
+	}
+	c.mu.Unlock()
+
+	var err error
+	for _, r := range expired {
+		if err = c.writer.WriteMessage(Message{Id: Cancel, Index: r.Index, Begin: r.Begin, Length: r.Length}); err != nil {
+			break
+		}
+	}
+	// Dropped may make new requests, so sendMu must be released first.
+	c.sendMu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.drop(expired)
+	return nil
+}
+
+// clearRequests removes and returns every outstanding request.  c.mu must be held.
+func (c *PeerConn) clearRequests() []BlockRequest {
+	var dropped []BlockRequest
+	for r := range c.requests {
+		dropped = append(dropped, r)
+	}
+	c.requests = map[BlockRequest]time.Time{}
+	return dropped
+}
+
+func (c *PeerConn) drop(requests []BlockRequest) {
+	if len(requests) > 0 && c.Dropped != nil {
+		c.Dropped(requests)
+	}
+}
+
+// ReadMessage reads the next message from the peer and updates the connection's state.
+// Blocks that weren't requested, or whose requests were cancelled, are still returned.
+// As with Reader, the payload of the message is only valid until the next call.  Suggest
+// and AllowedFast messages are checked and returned for the caller to act on.
+func (c *PeerConn) ReadMessage() (Message, error) {
+	m, err := c.reader.ReadMessage()
+	if err != nil {
+		return m, err
+	}
+	if m.KeepAlive {
+		return m, nil
+	}
+	c.mu.Lock()
+	first := !c.gotMessage
+	c.gotMessage = true
+	var dropped []BlockRequest
+	switch m.Id {
+	case Choke:
+		c.peerChoking = true
+		if !c.Peer.Capabilities.Has(CapabilityFast) {
+			dropped = c.clearRequests()
+		}
+	case Unchoke:
+		if c.peerChoking {
+			c.lastProgress = c.now()
+		}
+		c.peerChoking = false
+	case Interested:
+		c.peerInterested = true
+	case NotInterested:
+		c.peerInterested = false
+	case Have:
+		if int(m.Index) >= c.numPieces {
+			err = fmt.Errorf("Peer has piece %v of %v", m.Index, c.numPieces)
+			break
+		}
+		c.remotePieces.Set(int(m.Index))
+	case Bitfield:
+		if !first {
+			err = errors.New("Bitfield must be the first message")
+			break
+		}
+		var pieces *bitfield.Bitfield
+		if pieces, err = bitfield.FromBytes(m.Payload, c.numPieces); err == nil {
+			c.remotePieces = pieces
+		}
+	case Piece:
+		c.received(BlockRequest{m.Index, m.Begin, uint32(len(m.Payload))})
+	case HaveAll, HaveNone, RejectRequest, Suggest, AllowedFast:
+		if !c.Peer.Capabilities.Has(CapabilityFast) {
+			err = fmt.Errorf("Unexpected %v message without the fast extension", m.Id)
+			break
+		}
+		switch m.Id {
+		case HaveAll, HaveNone:
+			if !first {
+				err = fmt.Errorf("%v must be the first message", m.Id)
+				break
+			}
+			c.remotePieces = bitfield.New(c.numPieces)
+			if m.Id == HaveAll {
+				c.remotePieces.SetAll()
+			}
+		case RejectRequest:
+			r := BlockRequest{m.Index, m.Begin, m.Length}
+			if _, ok := c.requests[r]; ok {
+				delete(c.requests, r)
+				dropped = []BlockRequest{r}
+			}
+		default:
+			if int(m.Index) >= c.numPieces {
+				err = fmt.Errorf("Invalid piece %v of %v in %v message", m.Index, c.numPieces, m.Id)
+			}
+		}
+	}
+	c.mu.Unlock()
+	c.drop(dropped)
+	return m, err
+}
+
+// received records the arrival of a block, and retunes the pipeline.  c.mu must be held.
+func (c *PeerConn) received(r BlockRequest) {
+	if _, ok := c.requests[r]; !ok {
+		return
+	}
+	delete(c.requests, r)
+	now := c.now()
+	c.lastProgress = now
+	c.snubbed = false
+	if c.windowStart.IsZero() {
+		c.windowStart = now
+	}
+	c.windowBytes += int(r.Length)
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+	// The rate is a moving average, so that one slow window doesn't drain the pipeline.
+	rate := float64(c.windowBytes) / elapsed.Seconds()
+	if c.rate == 0 {
+		c.rate = rate
+	} else {
+		c.rate = (c.rate + rate) / 2
+	}
+	c.windowStart = now
+	c.windowBytes = 0
+	if r.Length > 0 {
+		c.pipeline = int(c.rate*c.RequestQueueTime.Seconds()) / int(r.Length)
+	}
+}
@@ -0,0 +1,334 @@
+package peer
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/optimality/gotorrent/bitfield"
+)
+
+// testPeer is the remote end of a PeerConn.
+type testPeer struct {
+	t        *testing.T
+	conn     net.Conn
+	received chan Message
+}
+
+func newTestPeerConn(t *testing.T, numPieces int) (*PeerConn, *testPeer, *time.Time) {
+	c1, c2 := net.Pipe()
+	c := NewPeerConn(c1, &HandshakeResult{}, numPieces)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	remote := &testPeer{t, c2, make(chan Message, 10000)}
+	go func() {
+		r := NewReader(c2)
+		for {
+			m, err := r.ReadMessage()
+			if err != nil {
+				close(remote.received)
+				return
+			}
+			m.Payload = append([]byte(nil), m.Payload...)
+			remote.received <- m
+		}
+	}()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	return c, remote, &now
+}
+
+// send sends messages to c, and has c read them.
+func (p *testPeer) send(c *PeerConn, messages ...Message) error {
+	go func() {
+		for _, m := range messages {
+			p.conn.Write(m.Bytes())
+		}
+	}()
+	for range messages {
+		if _, err := c.ReadMessage(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expect checks that the next messages c sent are expected.
+func (p *testPeer) expect(c *PeerConn, expected ...Message) {
+	if err := c.Flush(); err != nil {
+		p.t.Fatalf("Error flushing: %v", err)
+	}
+	for _, e := range expected {
+		select {
+		case m := <-p.received:
+			if !reflect.DeepEqual(m, e) {
+				p.t.Errorf("Expected %v, Actual %v", e, m)
+			}
+		case <-time.After(time.Second):
+			p.t.Fatalf("Expected %v, got nothing", e)
+		}
+	}
+}
+
+func TestPeerConnState(t *testing.T) {
+	c, remote, _ := newTestPeerConn(t, 10)
+	if !c.AmChoking() || c.AmInterested() || !c.PeerChoking() || c.PeerInterested() {
+		t.Errorf("Unexpected initial state")
+	}
+	err := remote.send(c, Message{Id: Bitfield, Payload: []byte{0xa0, 0x40}},
+		Message{Id: Have, Index: 9}, Message{Id: Unchoke}, Message{Id: Interested})
+	if err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if expected, actual := "0,2,9", c.RemotePieces().String(); expected != actual {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+	if !c.RemoteHas(9) || c.RemoteHas(1) || c.RemoteHas(10) {
+		t.Errorf("Unexpected pieces %v", c.RemotePieces())
+	}
+	have, _ := bitfield.FromBytes([]byte{0xa0, 0x00}, 10)
+	if !c.Interesting(have) {
+		t.Errorf("Expected piece 9 to be interesting")
+	}
+	have.Set(9)
+	if c.Interesting(have) {
+		t.Errorf("Expected no interesting pieces")
+	}
+	if c.PeerChoking() || !c.PeerInterested() {
+		t.Errorf("Expected the peer to be unchoking and interested")
+	}
+
+	// State messages are only sent when the state changes.
+	c.Interested()
+	c.Interested()
+	c.Unchoke()
+	c.Choke()
+	c.NotInterested()
+	remote.expect(c, Message{Id: Interested}, Message{Id: Unchoke}, Message{Id: Choke},
+		Message{Id: NotInterested})
+	if !c.AmChoking() || c.AmInterested() {
+		t.Errorf("Unexpected state")
+	}
+}
+
+func TestPeerConnStateOrdering(t *testing.T) {
+	c, remote, _ := newTestPeerConn(t, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if (i+j)%2 == 0 {
+					c.Interested()
+				} else {
+					c.NotInterested()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.Flush()
+	// The messages alternate, and the last one sent matches the final state.
+	var last Message
+	for {
+		select {
+		case m := <-remote.received:
+			if m.Id == last.Id {
+				t.Errorf("Got %v twice in a row", m)
+			}
+			last = m
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	expected := Message{Id: NotInterested}
+	if c.AmInterested() {
+		expected.Id = Interested
+	}
+	if !reflect.DeepEqual(expected, last) {
+		t.Errorf("Expected %v last, Actual %v", expected, last)
+	}
+}
+
+func TestPeerConnInvalidMessages(t *testing.T) {
+	for _, m := range []Message{
+		{Id: Have, Index: 10},
+		{Id: Bitfield, Payload: []byte{0xff}},
+		{Id: Bitfield, Payload: []byte{0xff, 0xff, 0x00}},
+		// The last piece is 9, so the rest of the final byte must be clear.
+		{Id: Bitfield, Payload: []byte{0xff, 0xe0}},
+	} {
+		c, remote, _ := newTestPeerConn(t, 10)
+		if err := remote.send(c, m); err == nil {
+			t.Errorf("%v: Expected an error", m)
+		}
+	}
+
+	c, remote, _ := newTestPeerConn(t, 10)
+	err := remote.send(c, Message{Id: Have, Index: 1}, Message{Id: Bitfield, Payload: []byte{0xff, 0xc0}})
+	if err == nil {
+		t.Errorf("Expected an error for a late bitfield")
+	}
+}
+
+func sortedRequests(requests []BlockRequest) []BlockRequest {
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Begin < requests[j].Begin })
+	return requests
+}
+
+func TestPeerConnRequests(t *testing.T) {
+	c, remote, _ := newTestPeerConn(t, 2)
+	var dropped []BlockRequest
+	c.Dropped = func(requests []BlockRequest) { dropped = append(dropped, requests...) }
+	block := func(begin uint32) BlockRequest { return BlockRequest{0, begin, 16384} }
+
+	remote.send(c, Message{Id: Have, Index: 0})
+	if ok, _ := c.Request(block(0)); ok || c.CanRequest() != 0 {
+		t.Errorf("Expected no requests while choked")
+	}
+	remote.send(c, Message{Id: Unchoke})
+	if c.CanRequest() != initialPipeline {
+		t.Errorf("Expected %v requests, got %v", initialPipeline, c.CanRequest())
+	}
+	if ok, _ := c.Request(BlockRequest{1, 0, 16384}); ok {
+		t.Errorf("Expected no request for a piece the peer doesn't have")
+	}
+	for i := uint32(0); i < initialPipeline; i++ {
+		if ok, err := c.Request(block(i * 16384)); !ok || err != nil {
+			t.Errorf("Request %v failed: %v", i, err)
+		}
+	}
+	if ok, _ := c.Request(block(0)); ok {
+		t.Errorf("Expected the pipeline to be full")
+	}
+	remote.expect(c, Message{Id: Request, Begin: 0, Length: 16384}, Message{Id: Request, Begin: 16384, Length: 16384},
+		Message{Id: Request, Begin: 32768, Length: 16384}, Message{Id: Request, Begin: 49152, Length: 16384})
+
+	remote.send(c, Message{Id: Piece, Begin: 16384, Payload: make([]byte, 16384)})
+	c.Cancel(block(32768))
+	remote.expect(c, Message{Id: Cancel, Begin: 32768, Length: 16384})
+	expected := []BlockRequest{block(0), block(49152)}
+	if actual := sortedRequests(c.Outstanding()); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+
+	// Being choked drops the remaining requests.
+	remote.send(c, Message{Id: Choke})
+	if len(c.Outstanding()) != 0 || !reflect.DeepEqual(expected, sortedRequests(dropped)) {
+		t.Errorf("Expected %v to be dropped, got %v", expected, dropped)
+	}
+	// A block that arrives late is still returned.
+	go remote.conn.Write(Message{Id: Piece, Payload: []byte("late")}.Bytes())
+	if m, err := c.ReadMessage(); err != nil || string(m.Payload) != "late" {
+		t.Errorf("Unexpected message %v, %v", m, err)
+	}
+}
+
+func TestPeerConnFastExtension(t *testing.T) {
+	c, remote, _ := newTestPeerConn(t, 2)
+	c.Peer.Capabilities.Set(CapabilityFast)
+	var dropped []BlockRequest
+	c.Dropped = func(requests []BlockRequest) { dropped = append(dropped, requests...) }
+	if err := remote.send(c, Message{Id: HaveAll}, Message{Id: Unchoke}); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if !c.RemotePieces().All() {
+		t.Errorf("Expected the peer to have every piece, got %v", c.RemotePieces())
+	}
+	first, second := BlockRequest{0, 0, 16384}, BlockRequest{1, 0, 16384}
+	c.Request(first)
+	c.Request(second)
+
+	// The peer rejects each request it won't answer, rather than the choke dropping them.
+	remote.send(c, Message{Id: Choke})
+	if len(c.Outstanding()) != 2 || dropped != nil {
+		t.Errorf("Expected both requests to be outstanding, got %v, dropped %v", c.Outstanding(), dropped)
+	}
+	remote.send(c, Message{Id: RejectRequest, Index: 1, Length: 16384})
+	if expected := []BlockRequest{first}; !reflect.DeepEqual(expected, c.Outstanding()) ||
+		!reflect.DeepEqual([]BlockRequest{second}, dropped) {
+		t.Errorf("Expected %v to be dropped, got %v, outstanding %v", second, dropped, c.Outstanding())
+	}
+	if err := remote.send(c, Message{Id: AllowedFast, Index: 1}, Message{Id: Suggest, Index: 0}); err != nil {
+		t.Errorf("Error reading: %v", err)
+	}
+
+	for _, messages := range [][]Message{
+		{{Id: Have, Index: 0}, {Id: HaveNone}},
+		{{Id: AllowedFast, Index: 2}},
+	} {
+		c, remote, _ := newTestPeerConn(t, 2)
+		c.Peer.Capabilities.Set(CapabilityFast)
+		if err := remote.send(c, messages...); err == nil {
+			t.Errorf("%v: Expected an error", messages)
+		}
+	}
+	c, remote, _ = newTestPeerConn(t, 2)
+	if err := remote.send(c, Message{Id: HaveAll}); err == nil {
+		t.Errorf("Expected an error for have all without the fast extension")
+	}
+	if c.RemotePieces().Count() != 0 {
+		t.Errorf("Expected no pieces, got %v", c.RemotePieces())
+	}
+}
+
+func TestPeerConnTimeouts(t *testing.T) {
+	c, remote, now := newTestPeerConn(t, 1)
+	var dropped []BlockRequest
+	c.Dropped = func(requests []BlockRequest) { dropped = append(dropped, requests...) }
+	remote.send(c, Message{Id: Have}, Message{Id: Unchoke})
+	first := BlockRequest{0, 0, 16384}
+	second := BlockRequest{0, 16384, 16384}
+	c.Request(first)
+	*now = now.Add(30 * time.Second)
+	c.Request(second)
+	remote.expect(c, Message{Id: Request, Length: 16384}, Message{Id: Request, Begin: 16384, Length: 16384})
+
+	*now = now.Add(30 * time.Second)
+	c.CheckTimeouts()
+	remote.expect(c, Message{Id: Cancel, Length: 16384})
+	if !reflect.DeepEqual(dropped, []BlockRequest{first}) {
+		t.Errorf("Expected %v to be dropped, got %v", first, dropped)
+	}
+	if !c.Snubbed() || c.Pipeline() != 1 || c.CanRequest() != 0 {
+		t.Errorf("Expected the peer to be snubbing us")
+	}
+
+	remote.send(c, Message{Id: Piece, Begin: 16384, Payload: make([]byte, 16384)})
+	if c.Snubbed() || c.Pipeline() != initialPipeline {
+		t.Errorf("Expected the peer to no longer be snubbing us")
+	}
+}
+
+func TestPeerConnPipelineTuning(t *testing.T) {
+	c, remote, now := newTestPeerConn(t, 1)
+	c.MaxPipeline = 1000
+	remote.send(c, Message{Id: Have}, Message{Id: Unchoke})
+
+	// Blocks arrive at 1MiB/s, so 3 seconds' worth is 192 blocks.
+	begin := uint32(0)
+	for i := 0; i < 3*64; i++ {
+		for c.CanRequest() > 0 {
+			c.Request(BlockRequest{0, begin, 16384})
+			begin += 16384
+		}
+		c.Flush()
+		r := c.Outstanding()[0]
+		*now = now.Add(time.Second / 64)
+		remote.send(c, Message{Id: Piece, Index: r.Index, Begin: r.Begin, Payload: make([]byte, r.Length)})
+	}
+	if pipeline := c.Pipeline(); pipeline < 180 || pipeline > 200 {
+		t.Errorf("Expected a pipeline of about 192, got %v", pipeline)
+	}
+	c.MaxPipeline = 50
+	if pipeline := c.Pipeline(); pipeline != 50 {
+		t.Errorf("Expected a pipeline of 50, got %v", pipeline)
+	}
+}
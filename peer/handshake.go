@@ -0,0 +1,184 @@
+// Package peer implements the BitTorrent peer wire protocol, as described in BEP 3 and
+// https://wiki.theory.org/BitTorrentSpecification#Peer_wire_protocol_.28TCP.29.
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// Protocol is the protocol string that starts every handshake.
+	Protocol = "BitTorrent protocol"
+	// HandshakeLength is the length of a handshake: the protocol string and its length,
+	// the reserved bytes, the info hash and the peer id.
+	HandshakeLength = 1 + len(Protocol) + 8 + 20 + 20
+	// DefaultHandshakeTimeout limits the time taken by a handshake if no timeout is given.
+	DefaultHandshakeTimeout = 20 * time.Second
+)
+
+// Capability is an extension to the protocol that peers advertise in the reserved bytes of
+// the handshake.
+type Capability int
+
+const (
+	CapabilityDHT       Capability = iota // The DHT port message, BEP 5
+	CapabilityFast                        // The fast extension, BEP 6
+	CapabilityExtension                   // The extension protocol, BEP 10
+)
+
+// capabilityBits gives the byte and bit of each capability in the reserved bytes.
+var capabilityBits = map[Capability]struct {
+	index int
+	mask  byte
+}{
+	CapabilityDHT:       {7, 0x01},
+	CapabilityFast:      {7, 0x04},
+	CapabilityExtension: {5, 0x10},
+}
+
+func (c Capability) String() string {
+	switch c {
+	case CapabilityDHT:
+		return "dht"
+	case CapabilityFast:
+		return "fast"
+	case CapabilityExtension:
+		return "extension"
+	default:
+		return fmt.Sprintf("Capability(%d)", int(c))
+	}
+}
+
+// Reserved holds the reserved bytes of a handshake.
+type Reserved [8]byte
+
+// Has reports whether c is set in r.
+func (r Reserved) Has(c Capability) bool {
+	bit, ok := capabilityBits[c]
+	return ok && r[bit.index]&bit.mask != 0
+}
+
+// Set sets c in r.
+func (r *Reserved) Set(c Capability) {
+	if bit, ok := capabilityBits[c]; ok {
+		r[bit.index] |= bit.mask
+	}
+}
+
+// Negotiate returns the known capabilities that are set in both r and remote.  Unknown
+// bits are dropped, since their meaning can't be agreed on.
+func (r Reserved) Negotiate(remote Reserved) Reserved {
+	var negotiated Reserved
+	for c := range capabilityBits {
+		if r.Has(c) && remote.Has(c) {
+			negotiated.Set(c)
+		}
+	}
+	return negotiated
+}
+
+// Handshake is the first message sent in each direction on a connection.
+type Handshake struct {
+	Reserved Reserved
+	InfoHash [20]byte
+	PeerId   [20]byte
+}
+
+// Bytes returns the wire encoding of h.
+func (h Handshake) Bytes() []byte {
+	b := make([]byte, 0, HandshakeLength)
+	b = append(b, byte(len(Protocol)))
+	b = append(b, Protocol...)
+	b = append(b, h.Reserved[:]...)
+	b = append(b, h.InfoHash[:]...)
+	return append(b, h.PeerId[:]...)
+}
+
+// ReadHandshake reads a handshake from r.
+func ReadHandshake(r io.Reader) (Handshake, error) {
+	var h Handshake
+	b := make([]byte, HandshakeLength)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return h, err
+	}
+	if int(b[0]) != len(Protocol) || string(b[1:1+len(Protocol)]) != Protocol {
+		return h, errors.New("Unknown peer protocol")
+	}
+	b = b[1+len(Protocol):]
+	copy(h.Reserved[:], b[:8])
+	copy(h.InfoHash[:], b[8:28])
+	copy(h.PeerId[:], b[28:])
+	return h, nil
+}
+
+// HandshakeResult describes the peer at the other end of a connection.
+type HandshakeResult struct {
+	// Handshake is the handshake sent by the remote peer.
+	Handshake
+	// Capabilities are the capabilities supported by both peers.
+	Capabilities Reserved
+}
+
+// Initiate sends local on conn and reads the remote peer's handshake, which must be for
+// the same torrent.  If remotePeerId is not nil, the remote peer must also have that id,
+// as when connecting to a peer returned by a tracker.  The handshake must complete within
+// timeout, or DefaultHandshakeTimeout if it is zero.
+func Initiate(conn net.Conn, local Handshake, remotePeerId *[20]byte, timeout time.Duration) (*HandshakeResult, error) {
+	if err := setHandshakeDeadline(conn, timeout); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(local.Bytes()); err != nil {
+		return nil, err
+	}
+	remote, err := ReadHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	if remote.InfoHash != local.InfoHash {
+		return nil, fmt.Errorf("Peer sent info hash %x, expected %x", remote.InfoHash, local.InfoHash)
+	}
+	if remotePeerId != nil && remote.PeerId != *remotePeerId {
+		return nil, fmt.Errorf("Peer sent peer id %q, expected %q", remote.PeerId, *remotePeerId)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &HandshakeResult{remote, local.Reserved.Negotiate(remote.Reserved)}, nil
+}
+
+// Accept reads the handshake of a peer that connected to us, and replies with the
+// handshake returned by lookup for the requested info hash.  If lookup returns false, the
+// torrent isn't being served and the handshake fails without a reply.  The handshake
+// must complete within timeout, or DefaultHandshakeTimeout if it is zero.
+func Accept(conn net.Conn, lookup func(infoHash [20]byte) (Handshake, bool), timeout time.Duration) (*HandshakeResult, error) {
+	if err := setHandshakeDeadline(conn, timeout); err != nil {
+		return nil, err
+	}
+	remote, err := ReadHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	local, ok := lookup(remote.InfoHash)
+	if !ok {
+		return nil, fmt.Errorf("Peer requested unknown info hash %x", remote.InfoHash)
+	}
+	local.InfoHash = remote.InfoHash
+	if _, err := conn.Write(local.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &HandshakeResult{remote, local.Reserved.Negotiate(remote.Reserved)}, nil
+}
+
+func setHandshakeDeadline(conn net.Conn, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	return conn.SetDeadline(time.Now().Add(timeout))
+}
@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func testHandshake(infoHash, peerId string, capabilities ...Capability) Handshake {
+	var h Handshake
+	copy(h.InfoHash[:], infoHash)
+	copy(h.PeerId[:], peerId)
+	for _, c := range capabilities {
+		h.Reserved.Set(c)
+	}
+	return h
+}
+
+func TestHandshakeBytes(t *testing.T) {
+	h := testHandshake("aaaaaaaaaaaaaaaaaaaa", "-GT0100-bbbbbbbbbbbb", CapabilityDHT, CapabilityExtension)
+	expected := "\x13BitTorrent protocol\x00\x00\x00\x00\x00\x10\x00\x01" +
+		"aaaaaaaaaaaaaaaaaaaa-GT0100-bbbbbbbbbbbb"
+	if actual := string(h.Bytes()); actual != expected {
+		t.Errorf("Expected %q, Actual %q", expected, actual)
+	}
+	parsed, err := ReadHandshake(bytes.NewReader(h.Bytes()))
+	if err != nil || parsed != h {
+		t.Errorf("Expected %v, Actual %v, %v", h, parsed, err)
+	}
+	if _, err := ReadHandshake(bytes.NewReader([]byte("\x13BitTorrent protocoX" + expected[20:]))); err == nil {
+		t.Errorf("Expected an error for an unknown protocol")
+	}
+	if _, err := ReadHandshake(bytes.NewReader([]byte(expected[:67]))); err == nil {
+		t.Errorf("Expected an error for a short handshake")
+	}
+}
+
+func TestReservedNegotiate(t *testing.T) {
+	local := testHandshake("", "", CapabilityDHT, CapabilityFast).Reserved
+	remote := testHandshake("", "", CapabilityFast, CapabilityExtension).Reserved
+	remote[0] = 0xff
+	negotiated := local.Negotiate(remote)
+	if negotiated.Has(CapabilityDHT) || !negotiated.Has(CapabilityFast) || negotiated.Has(CapabilityExtension) {
+		t.Errorf("Unexpected capabilities %v", negotiated)
+	}
+	if negotiated[0] != 0 {
+		t.Errorf("Expected unknown bits to be dropped, got %v", negotiated)
+	}
+}
+
+type handshakeResult struct {
+	result *HandshakeResult
+	err    error
+}
+
+// pipeHandshake runs Initiate and Accept on either end of a pipe.
+func pipeHandshake(local Handshake, remotePeerId *[20]byte, lookup func([20]byte) (Handshake, bool)) (initiated, accepted handshakeResult) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	done := make(chan handshakeResult)
+	go func() {
+		result, err := Accept(c2, lookup, time.Second)
+		if err != nil {
+			c2.Close()
+		}
+		done <- handshakeResult{result, err}
+	}()
+	result, err := Initiate(c1, local, remotePeerId, time.Second)
+	if err != nil {
+		c1.Close()
+	}
+	return handshakeResult{result, err}, <-done
+}
+
+func TestHandshake(t *testing.T) {
+	local := testHandshake("aaaaaaaaaaaaaaaaaaaa", "-GT0100-initiatorxxx", CapabilityDHT, CapabilityFast)
+	remote := testHandshake("", "-GT0100-acceptorxxxx", CapabilityFast, CapabilityExtension)
+	lookup := func(infoHash [20]byte) (Handshake, bool) {
+		return remote, infoHash == local.InfoHash
+	}
+
+	initiated, accepted := pipeHandshake(local, &remote.PeerId, lookup)
+	if initiated.err != nil || accepted.err != nil {
+		t.Fatalf("Handshake failed: %v, %v", initiated.err, accepted.err)
+	}
+	if initiated.result.PeerId != remote.PeerId || initiated.result.InfoHash != local.InfoHash {
+		t.Errorf("Unexpected handshake %+v", initiated.result)
+	}
+	if accepted.result.Handshake != local {
+		t.Errorf("Expected %v, Actual %v", local, accepted.result.Handshake)
+	}
+	for _, result := range []*HandshakeResult{initiated.result, accepted.result} {
+		if !result.Capabilities.Has(CapabilityFast) || result.Capabilities.Has(CapabilityDHT) ||
+			result.Capabilities.Has(CapabilityExtension) {
+			t.Errorf("Unexpected capabilities %v", result.Capabilities)
+		}
+	}
+
+	// Any peer id is accepted when none is expected.
+	if initiated, _ := pipeHandshake(local, nil, lookup); initiated.err != nil {
+		t.Errorf("Handshake failed: %v", initiated.err)
+	}
+	wrongId := remote.PeerId
+	wrongId[0] = 'X'
+	if initiated, _ := pipeHandshake(local, &wrongId, lookup); initiated.err == nil {
+		t.Errorf("Expected an error for the wrong peer id")
+	}
+
+	unknown := local
+	unknown.InfoHash[0] = 'b'
+	initiated, accepted = pipeHandshake(unknown, nil, lookup)
+	if initiated.err == nil || accepted.err == nil {
+		t.Errorf("Expected errors for an unknown info hash, got %v, %v", initiated.err, accepted.err)
+	}
+}
+
+func TestHandshakeInfoHashMismatch(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		ReadHandshake(c2)
+		c2.Write(testHandshake("bbbbbbbbbbbbbbbbbbbb", "").Bytes())
+	}()
+	local := testHandshake("aaaaaaaaaaaaaaaaaaaa", "")
+	if _, err := Initiate(c1, local, nil, time.Second); err == nil {
+		t.Errorf("Expected an error for a different info hash")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	// The remote peer reads the handshake but never replies.
+	go ReadHandshake(c2)
+	start := time.Now()
+	_, err := Initiate(c1, testHandshake("aaaaaaaaaaaaaaaaaaaa", ""), nil, 50*time.Millisecond)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Timeout took %v", elapsed)
+	}
+
+	c3, c4 := net.Pipe()
+	defer c3.Close()
+	defer c4.Close()
+	_, err = Accept(c3, func([20]byte) (Handshake, bool) { return Handshake{}, true }, 50*time.Millisecond)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package peer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// MessageId identifies the type of a message.
+type MessageId byte
+
+const (
+	Choke         MessageId = 0
+	Unchoke       MessageId = 1
+	Interested    MessageId = 2
+	NotInterested MessageId = 3
+	Have          MessageId = 4
+	Bitfield      MessageId = 5
+	Request       MessageId = 6
+	Piece         MessageId = 7
+	Cancel        MessageId = 8
+	Port          MessageId = 9
+
+	// The fast extension, BEP 6.
+	Suggest       MessageId = 0x0d
+	HaveAll       MessageId = 0x0e
+	HaveNone      MessageId = 0x0f
+	RejectRequest MessageId = 0x10
+	AllowedFast   MessageId = 0x11
+)
+
+// DefaultMaxMessageLength limits the length of messages read if no other limit is set.
+// It allows the bitfield of a torrent with two million pieces, and blocks far larger than
+// the usual 16KiB.
+const DefaultMaxMessageLength = 1 << 18
+
+// writeBufferSize is the most a Writer buffers before writing to the connection.
+const writeBufferSize = 1 << 16
+
+func (id MessageId) String() string {
+	switch id {
+	case Choke:
+		return "choke"
+	case Unchoke:
+		return "unchoke"
+	case Interested:
+		return "interested"
+	case NotInterested:
+		return "not interested"
+	case Have:
+		return "have"
+	case Bitfield:
+		return "bitfield"
+	case Request:
+		return "request"
+	case Piece:
+		return "piece"
+	case Cancel:
+		return "cancel"
+	case Port:
+		return "port"
+	case Suggest:
+		return "suggest"
+	case HaveAll:
+		return "have all"
+	case HaveNone:
+		return "have none"
+	case RejectRequest:
+		return "reject request"
+	case AllowedFast:
+		return "allowed fast"
+	default:
+		return fmt.Sprintf("MessageId(%d)", int(id))
+	}
+}
+
+// Message is a single peer wire message.  Which fields are used depends on Id:
+// Have, Suggest and AllowedFast use Index; Request, Cancel and RejectRequest use Index,
+// Begin and Length; Piece uses Index, Begin and Payload, the block; Bitfield uses Payload,
+// the bitfield; and Port uses Port.
+// Messages with other ids, from extensions, keep everything after the id in Payload.
+type Message struct {
+	// KeepAlive is set for the empty keep-alive message, which has no id.
+	KeepAlive bool
+	Id        MessageId
+	Index     uint32
+	Begin     uint32
+	Length    uint32
+	Payload   []byte
+	Port      uint16
+}
+
+func (m Message) String() string {
+	if m.KeepAlive {
+		return "keep-alive"
+	}
+	switch m.Id {
+	case Have, Suggest, AllowedFast:
+		return fmt.Sprintf("%v %v", m.Id, m.Index)
+	case Request, Cancel, RejectRequest:
+		return fmt.Sprintf("%v %v %v %v", m.Id, m.Index, m.Begin, m.Length)
+	case Piece:
+		return fmt.Sprintf("piece %v %v [%v bytes]", m.Index, m.Begin, len(m.Payload))
+	case Bitfield:
+		return fmt.Sprintf("bitfield [%v bytes]", len(m.Payload))
+	case Port:
+		return fmt.Sprintf("port %v", m.Port)
+	default:
+		return m.Id.String()
+	}
+}
+
+// header returns the length prefix, id and fixed fields of m, which are followed on the
+// wire by the payload.
+func (m Message) header() []byte {
+	if m.KeepAlive {
+		return make([]byte, 4)
+	}
+	var fields []byte
+	switch m.Id {
+	case Choke, Unchoke, Interested, NotInterested, HaveAll, HaveNone:
+	case Have, Suggest, AllowedFast:
+		fields = binary.BigEndian.AppendUint32(fields, m.Index)
+	case Request, Cancel, RejectRequest:
+		fields = binary.BigEndian.AppendUint32(fields, m.Index)
+		fields = binary.BigEndian.AppendUint32(fields, m.Begin)
+		fields = binary.BigEndian.AppendUint32(fields, m.Length)
+	case Piece:
+		fields = binary.BigEndian.AppendUint32(fields, m.Index)
+		fields = binary.BigEndian.AppendUint32(fields, m.Begin)
+	case Port:
+		fields = binary.BigEndian.AppendUint16(fields, m.Port)
+	}
+	length := 1 + len(fields) + len(m.payload())
+	header := binary.BigEndian.AppendUint32(make([]byte, 0, 5+len(fields)), uint32(length))
+	header = append(header, byte(m.Id))
+	return append(header, fields...)
+}
+
+// payload returns the part of m.Payload that is sent with m.
+func (m Message) payload() []byte {
+	switch m.Id {
+	case Choke, Unchoke, Interested, NotInterested, Have, Request, Cancel, Port,
+		Suggest, HaveAll, HaveNone, RejectRequest, AllowedFast:
+		return nil
+	}
+	if m.KeepAlive {
+		return nil
+	}
+	return m.Payload
+}
+
+// Bytes returns the wire encoding of m.
+func (m Message) Bytes() []byte {
+	return append(m.header(), m.payload()...)
+}
+
+// Reader reads messages from a connection.
+type Reader struct {
+	// MaxLength limits the length of messages.  Zero means DefaultMaxMessageLength.
+	MaxLength uint32
+
+	r   *bufio.Reader
+	buf []byte
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadMessage reads the next message.  To avoid copying blocks, the Payload of the
+// message refers to a buffer that is reused, and is only valid until the next call to
+// ReadMessage.
+func (r *Reader) ReadMessage() (Message, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		return Message{}, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length == 0 {
+		return Message{KeepAlive: true}, nil
+	}
+	maxLength := r.MaxLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxMessageLength
+	}
+	if length > maxLength {
+		return Message{}, fmt.Errorf("Message length %v exceeds maximum %v", length, maxLength)
+	}
+	if uint32(cap(r.buf)) < length {
+		r.buf = make([]byte, length)
+	}
+	b := r.buf[:length]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Message{}, err
+	}
+	return parseMessage(b)
+}
+
+// messageLengths gives the length of each message without its length prefix, or the
+// minimum length for messages with a payload.
+var messageLengths = map[MessageId]int{
+	Choke:         1,
+	Unchoke:       1,
+	Interested:    1,
+	NotInterested: 1,
+	Have:          5,
+	Bitfield:      1,
+	Request:       13,
+	Piece:         9,
+	Cancel:        13,
+	Port:          3,
+	Suggest:       5,
+	HaveAll:       1,
+	HaveNone:      1,
+	RejectRequest: 13,
+	AllowedFast:   5,
+}
+
+// parseMessage parses b, a message without its length prefix.
+func parseMessage(b []byte) (Message, error) {
+	m := Message{Id: MessageId(b[0])}
+	expected, known := messageLengths[m.Id]
+	if !known {
+		m.Payload = b[1:]
+		return m, nil
+	}
+	hasPayload := m.Id == Bitfield || m.Id == Piece
+	if len(b) < expected || (!hasPayload && len(b) != expected) {
+		return Message{}, fmt.Errorf("Invalid length %v for %v message", len(b), m.Id)
+	}
+	switch m.Id {
+	case Have, Suggest, AllowedFast:
+		m.Index = binary.BigEndian.Uint32(b[1:])
+	case Request, Cancel, RejectRequest:
+		m.Index = binary.BigEndian.Uint32(b[1:])
+		m.Begin = binary.BigEndian.Uint32(b[5:])
+		m.Length = binary.BigEndian.Uint32(b[9:])
+	case Piece:
+		m.Index = binary.BigEndian.Uint32(b[1:])
+		m.Begin = binary.BigEndian.Uint32(b[5:])
+		m.Payload = b[9:]
+	case Bitfield:
+		m.Payload = b[1:]
+	case Port:
+		m.Port = binary.BigEndian.Uint16(b[1:])
+	}
+	return m, nil
+}
+
+// Writer writes messages to a connection.  Messages are buffered so that a series of
+// small messages goes out in a single write, and are only sent on Flush or once the
+// buffer is full.  Payloads which fit are copied into the buffer; a larger payload, such
+// as a block, isn't copied but is sent together with the buffer in one vectored write.
+// A Writer is safe for concurrent use.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteMessage adds m to the buffer, writing the buffer out if it is full.  The payload
+// of m may be written before WriteMessage returns, and must not be changed until it has.
+func (w *Writer) WriteMessage(m Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, m.header()...)
+	payload := m.payload()
+	if len(w.buf)+len(payload) <= writeBufferSize {
+		w.buf = append(w.buf, payload...)
+		return nil
+	}
+	buffers := net.Buffers{w.buf, payload}
+	_, err := buffers.WriteTo(w.w)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// Flush writes any buffered messages.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var messageTests = []struct {
+	message Message
+	encoded string
+}{
+	{Message{KeepAlive: true}, "\x00\x00\x00\x00"},
+	{Message{Id: Choke}, "\x00\x00\x00\x01\x00"},
+	{Message{Id: Unchoke}, "\x00\x00\x00\x01\x01"},
+	{Message{Id: Interested}, "\x00\x00\x00\x01\x02"},
+	{Message{Id: NotInterested}, "\x00\x00\x00\x01\x03"},
+	{Message{Id: Have, Index: 0x01020304}, "\x00\x00\x00\x05\x04\x01\x02\x03\x04"},
+	{Message{Id: Bitfield, Payload: []byte{0xff, 0x80}}, "\x00\x00\x00\x03\x05\xff\x80"},
+	{Message{Id: Request, Index: 1, Begin: 0x4000, Length: 0x4000},
+		"\x00\x00\x00\x0d\x06\x00\x00\x00\x01\x00\x00\x40\x00\x00\x00\x40\x00"},
+	{Message{Id: Piece, Index: 1, Begin: 2, Payload: []byte("block")},
+		"\x00\x00\x00\x0e\x07\x00\x00\x00\x01\x00\x00\x00\x02block"},
+	{Message{Id: Cancel, Index: 1, Begin: 2, Length: 3},
+		"\x00\x00\x00\x0d\x08\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00\x03"},
+	{Message{Id: Port, Port: 6881}, "\x00\x00\x00\x03\x09\x1a\xe1"},
+	{Message{Id: Suggest, Index: 3}, "\x00\x00\x00\x05\x0d\x00\x00\x00\x03"},
+	{Message{Id: HaveAll}, "\x00\x00\x00\x01\x0e"},
+	{Message{Id: HaveNone}, "\x00\x00\x00\x01\x0f"},
+	{Message{Id: RejectRequest, Index: 1, Begin: 2, Length: 3},
+		"\x00\x00\x00\x0d\x10\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00\x03"},
+	{Message{Id: AllowedFast, Index: 4}, "\x00\x00\x00\x05\x11\x00\x00\x00\x04"},
+	{Message{Id: 20, Payload: []byte("\x00d1:md6:lt_texi1eee")}, "\x00\x00\x00\x14\x14\x00d1:md6:lt_texi1eee"},
+}
+
+func TestMessageEncoding(t *testing.T) {
+	for _, test := range messageTests {
+		if actual := string(test.message.Bytes()); actual != test.encoded {
+			t.Errorf("%v: Expected %q, Actual %q", test.message, test.encoded, actual)
+		}
+		m, err := NewReader(strings.NewReader(test.encoded)).ReadMessage()
+		if err != nil {
+			t.Errorf("%v: Error reading: %v", test.message, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.message, m) {
+			t.Errorf("Expected %#v, Actual %#v", test.message, m)
+		}
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	for _, encoded := range []string{
+		"\x00\x00\x00\x02\x00\x00",                          // Choke with a payload
+		"\x00\x00\x00\x04\x04\x00\x00\x00",                  // Short have
+		"\x00\x00\x00\x0e\x06" + strings.Repeat("\x00", 13), // Long request
+		"\x00\x00\x00\x08\x07\x00\x00\x00\x00\x00\x00\x00",  // Short piece
+		"\x00\x00\x00\x05\x04\x00",                          // Truncated
+		"\x00\x00",                                          // Truncated length
+		"\x00\x04\x00\x01\x07",                              // Longer than the maximum
+	} {
+		if m, err := NewReader(strings.NewReader(encoded)).ReadMessage(); err == nil {
+			t.Errorf("%q: Expected an error, got %v", encoded, m)
+		}
+	}
+
+	r := NewReader(strings.NewReader("\x00\x00\x00\x03\x05\xff\x80"))
+	r.MaxLength = 2
+	if _, err := r.ReadMessage(); err == nil {
+		t.Errorf("Expected an error for a message longer than MaxLength")
+	}
+	if _, err := NewReader(strings.NewReader("")).ReadMessage(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestReaderReusesBuffer(t *testing.T) {
+	encoded := Message{Id: Piece, Payload: []byte("first")}.Bytes()
+	encoded = append(encoded, Message{Id: Piece, Payload: []byte("other")}.Bytes()...)
+	r := NewReader(bytes.NewReader(encoded))
+	first, _ := r.ReadMessage()
+	block := first.Payload
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if string(block) != "other" {
+		t.Errorf("Expected the block to share the reader's buffer, got %q", block)
+	}
+}
+
+// countingWriter records each write.
+type countingWriter struct {
+	writes [][]byte
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestWriterBatches(t *testing.T) {
+	out := &countingWriter{}
+	w := NewWriter(out)
+	var expected []byte
+	for _, m := range []Message{{Id: Interested}, {Id: Have, Index: 3}, {Id: Request, Length: 16384}} {
+		if err := w.WriteMessage(m); err != nil {
+			t.Fatalf("Error writing: %v", err)
+		}
+		expected = append(expected, m.Bytes()...)
+	}
+	if len(out.writes) != 0 {
+		t.Errorf("Expected no writes before Flush, got %v", len(out.writes))
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if len(out.writes) != 1 || !bytes.Equal(out.writes[0], expected) {
+		t.Errorf("Expected a single write of %q, got %q", expected, out.writes)
+	}
+
+	// A large block is written straight away, after the buffered messages.
+	out.writes = nil
+	w.WriteMessage(Message{Id: Unchoke})
+	piece := Message{Id: Piece, Index: 1, Payload: make([]byte, writeBufferSize)}
+	w.WriteMessage(piece)
+	written := bytes.Join(out.writes, nil)
+	if expected := append(Message{Id: Unchoke}.Bytes(), piece.Bytes()...); !bytes.Equal(written, expected) {
+		t.Errorf("Unexpected write of %v bytes", len(written))
+	}
+	w.Flush()
+	if len(bytes.Join(out.writes, nil)) != len(written) {
+		t.Errorf("Expected nothing left to flush")
+	}
+}
+
+func FuzzReadMessage(f *testing.F) {
+	for _, test := range messageTests {
+		f.Add([]byte(test.encoded))
+	}
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		r := NewReader(bytes.NewReader(encoded))
+		r.MaxLength = 1 << 16
+		m, err := r.ReadMessage()
+		if err != nil {
+			return
+		}
+		// A message that was read is encoded exactly as it was received.
+		reencoded := m.Bytes()
+		if !bytes.Equal(reencoded, encoded[:len(reencoded)]) {
+			t.Errorf("Read %q as %v, which encodes as %q", encoded, m, reencoded)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package gotorrent
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultPeerIdPrefix is the Azureus-style prefix of our peer ids: "GT" for gotorrent,
+// then the version, 0.1.0.0.
+const DefaultPeerIdPrefix = "-GT0100-"
+
+// DefaultPort is the port we listen on unless told otherwise.
+const DefaultPort = "6881"
+
+const peerIdLength = 20
+
+const peerIdChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// NewPeerId returns a new peer id with DefaultPeerIdPrefix and a random suffix.
+func NewPeerId() string {
+	peerId, _ := GeneratePeerId(DefaultPeerIdPrefix)
+	return peerId
+}
+
+// GeneratePeerId returns a new peer id which starts with prefix and ends with random
+// letters and digits from crypto/rand, so that other peers can't predict it.
+func GeneratePeerId(prefix string) (string, error) {
+	if len(prefix) > peerIdLength {
+		return "", fmt.Errorf("Peer id prefix %q is longer than %v bytes", prefix, peerIdLength)
+	}
+	suffix := make([]byte, 0, peerIdLength-len(prefix))
+	random := make([]byte, 1)
+	for len(suffix) < cap(suffix) {
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		// Bytes past the last multiple of len(peerIdChars) would favour the first letters.
+		if int(random[0]) < 256/len(peerIdChars)*len(peerIdChars) {
+			suffix = append(suffix, peerIdChars[int(random[0])%len(peerIdChars)])
+		}
+	}
+	return prefix + string(suffix), nil
+}
+
+// PeerClient is the client software identified from a peer id.
+type PeerClient struct {
+	Name    string
+	Version string
+}
+
+func (c PeerClient) String() string {
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Version
+}
+
+// azureusClients are the client codes of Azureus-style peer ids, "-XX1234-".
+var azureusClients = map[string]string{
+	"AG": "Ares",
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"FW": "FrostWire",
+	"GT": "gotorrent",
+	"KG": "KGet",
+	"KT": "KTorrent",
+	"LT": "libtorrent (Rasterbar)",
+	"LW": "LimeWire",
+	"PI": "PicoTorrent",
+	"SD": "Thunder",
+	"TR": "Transmission",
+	"TT": "TuoTu",
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+	"lt": "libTorrent (rakshasa)",
+	"qB": "qBittorrent",
+}
+
+// shadowClients are the client codes of Shadow-style peer ids, a letter followed by up
+// to five version characters and dashes.
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+const shadowVersionChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz.-"
+
+var mainlinePeerId = regexp.MustCompile(`^M(\d+)-(\d+)-(\d+)-`)
+
+// IdentifyClient returns the client which generated peerId, for the common peer id
+// conventions.  See http://bittorrent.org/beps/bep_0020.html.
+func IdentifyClient(peerId string) (PeerClient, bool) {
+	if len(peerId) != peerIdLength {
+		return PeerClient{}, false
+	}
+
+	if peerId[0] == '-' && peerId[7] == '-' {
+		name, ok := azureusClients[peerId[1:3]]
+		if !ok {
+			return PeerClient{}, false
+		}
+		return PeerClient{name, azureusVersion(peerId[1:3], peerId[3:7])}, true
+	}
+
+	if m := mainlinePeerId.FindStringSubmatch(peerId); m != nil {
+		return PeerClient{"BitTorrent", m[1] + "." + m[2] + "." + m[3]}, true
+	}
+
+	if name, ok := shadowClients[peerId[0]]; ok && strings.Contains(peerId[1:9], "--") {
+		var version []string
+		for i := 1; i < 6 && peerId[i] != '-'; i++ {
+			v := strings.IndexByte(shadowVersionChars, peerId[i])
+			if v < 0 {
+				return PeerClient{}, false
+			}
+			version = append(version, strconv.Itoa(v))
+		}
+		return PeerClient{name, strings.Join(version, ".")}, true
+	}
+	return PeerClient{}, false
+}
+
+// azureusVersion formats the four version characters of an Azureus-style peer id.
+func azureusVersion(client, version string) string {
+	// Transmission uses a major version and a two digit minor version, with an optional
+	// suffix such as Z for development builds.
+	if client == "TR" {
+		if minor, err := strconv.Atoi(version[1:3]); err == nil {
+			return fmt.Sprintf("%c.%02d", version[0], minor)
+		}
+	}
+	var parts []string
+	// libTorrent uses a hex digit for each part.
+	if client == "lt" {
+		for i := 0; i < len(version); i++ {
+			v, err := strconv.ParseUint(version[i:i+1], 16, 8)
+			if err != nil {
+				return version
+			}
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+		return strings.Join(parts, ".")
+	}
+	for i := 0; i < len(version); i++ {
+		c := version[i]
+		if c < '0' || c > '9' {
+			// A release type, such as B for beta.
+			return strings.Join(parts, ".") + version[i:]
+		}
+		parts = append(parts, string(c))
+	}
+	return strings.Join(parts, ".")
+}
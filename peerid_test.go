@@ -0,0 +1,52 @@
+package gotorrent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePeerId(t *testing.T) {
+	a, b := NewPeerId(), NewPeerId()
+	if len(a) != 20 || !strings.HasPrefix(a, DefaultPeerIdPrefix) {
+		t.Errorf("Invalid peer id %q", a)
+	}
+	if a == b {
+		t.Errorf("Expected different peer ids, got %q twice", a)
+	}
+	if client, ok := IdentifyClient(a); !ok || client.String() != "gotorrent 0.1.0.0" {
+		t.Errorf("Couldn't identify our own peer id %q: %v", a, client)
+	}
+
+	peerId, err := GeneratePeerId("-XY1234-custom")
+	if err != nil || len(peerId) != 20 || !strings.HasPrefix(peerId, "-XY1234-custom") {
+		t.Errorf("Invalid peer id %q, %v", peerId, err)
+	}
+	if _, err := GeneratePeerId(strings.Repeat("x", 21)); err == nil {
+		t.Errorf("Expected an error for a long prefix")
+	}
+}
+
+func TestIdentifyClient(t *testing.T) {
+	tests := map[string]string{
+		"-qB4250-abcdefghijkl": "qBittorrent 4.2.5.0",
+		"-TR2940-abcdefghijkl": "Transmission 2.94",
+		"-TR300Z-abcdefghijkl": "Transmission 3.00",
+		"-UT355W-abcdefghijkl": "µTorrent 3.5.5W",
+		"-lt0D60-abcdefghijkl": "libTorrent (rakshasa) 0.13.6.0",
+		"M4-4-0--abcdefghijkl": "BitTorrent 4.4.0",
+		"M7-10-2-abcdefghijkl": "BitTorrent 7.10.2",
+		"S58B-----abcdefghijk": "Shadow 5.8.11",
+		"T03I--00abcdefghijkl": "BitTornado 0.3.18",
+	}
+	for peerId, expected := range tests {
+		client, ok := IdentifyClient(peerId)
+		if !ok || client.String() != expected {
+			t.Errorf("For %q expected %v, Actual %v", peerId, expected, client)
+		}
+	}
+	for _, peerId := range []string{"-ZZ1234-abcdefghijkl", "abcdefghijklmnopqrst", "-qB4250-", ""} {
+		if client, ok := IdentifyClient(peerId); ok {
+			t.Errorf("Unexpectedly identified %q as %v", peerId, client)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package gotorrent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+// maxHTTPScrapeSize is the most info hashes sent in a single HTTP scrape, to keep the
+// url a reasonable length.
+const maxHTTPScrapeSize = 50
+
+// ScrapeStats are the statistics for a single torrent returned by a scrape.
+type ScrapeStats struct {
+	Complete   int // Seeders
+	Downloaded int // Number of times the torrent has been downloaded
+	Incomplete int // Leechers
+}
+
+type scrapeResponse struct {
+	FailureReason string
+	Files         map[InfoHash]ScrapeStats
+}
+
+// ScrapeUrl returns the scrape url for an HTTP tracker's announce url.  By convention,
+// this replaces "announce" at the start of the last path component with "scrape"; trackers
+// whose announce urls don't follow this pattern don't support scraping.
+// See https://wiki.theory.org/BitTorrentSpecification#Tracker_.27scrape.27_Convention.
+func ScrapeUrl(announceUrl string) (string, error) {
+	u, err := url.Parse(announceUrl)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't parse url %v", announceUrl)
+	}
+	slash := strings.LastIndex(u.Path, "/")
+	if slash < 0 || !strings.HasPrefix(u.Path[slash+1:], "announce") {
+		return "", fmt.Errorf("Tracker %v doesn't support scrape", announceUrl)
+	}
+	u.Path = u.Path[:slash+1] + "scrape" + strings.TrimPrefix(u.Path[slash+1:], "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Scrape asks the tracker for the statistics of the torrents with the given info hashes.
+// Requests are batched, so any number of info hashes may be given.  Torrents which the
+// tracker doesn't know about are missing from the result.
+func (t *Tracker) Scrape(ctx context.Context, infoHashes ...InfoHash) (map[InfoHash]ScrapeStats, error) {
+	trackerUrl, err := url.Parse(t.Url)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse url %v", t.Url)
+	}
+	var scrape func([]InfoHash) (map[InfoHash]ScrapeStats, error)
+	batchSize := 0
+	switch trackerUrl.Scheme {
+	case "http", "https":
+		scrapeUrl, err := ScrapeUrl(t.Url)
+		if err != nil {
+			return nil, err
+		}
+		batchSize = maxHTTPScrapeSize
+		scrape = func(batch []InfoHash) (map[InfoHash]ScrapeStats, error) {
+			return t.scrapeHTTP(ctx, scrapeUrl, batch)
+		}
+	case "udp":
+		batchSize = maxUDPScrapeSize
+		scrape = func(batch []InfoHash) (map[InfoHash]ScrapeStats, error) {
+			return t.scrapeUDP(ctx, trackerUrl.Host, batch)
+		}
+	case "ws", "wss":
+		batchSize = maxHTTPScrapeSize
+		scrape = func(batch []InfoHash) (map[InfoHash]ScrapeStats, error) {
+			return t.scrapeWebSocket(ctx, batch)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported tracker protocol %v", t.Url)
+	}
+
+	stats := map[InfoHash]ScrapeStats{}
+	for start := 0; start < len(infoHashes); start += batchSize {
+		end := start + batchSize
+		if end > len(infoHashes) {
+			end = len(infoHashes)
+		}
+		batchStats, err := scrape(infoHashes[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for h, s := range batchStats {
+			stats[h] = s
+		}
+	}
+	return stats, nil
+}
+
+func (t *Tracker) scrapeHTTP(ctx context.Context, scrapeUrl string, infoHashes []InfoHash) (map[InfoHash]ScrapeStats, error) {
+	u, err := url.Parse(scrapeUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse url %v", scrapeUrl)
+	}
+	params := make([]string, len(infoHashes))
+	for i, h := range infoHashes {
+		params[i] = "info_hash=" + escapeQueryBytes(string(h))
+	}
+	appendQuery(u, strings.Join(params, "&"))
+
+	resp, body, err := t.get(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var response scrapeResponse
+	err = bencoding.Unmarshal(string(body), &response)
+	if err == nil && response.FailureReason != "" {
+		return nil, &TrackerFailure{t.Url, response.FailureReason}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Non-200 response from %v: %v", scrapeUrl, resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse response from %v: %v", scrapeUrl, err)
+	}
+	if response.Files == nil {
+		response.Files = map[InfoHash]ScrapeStats{}
+	}
+	return response.Files, nil
+}
@@ -0,0 +1,110 @@
+package gotorrent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScrapeUrl(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com/announce":          "http://example.com/scrape",
+		"http://example.com/x/announce":        "http://example.com/x/scrape",
+		"http://example.com/announce.php":      "http://example.com/scrape.php",
+		"http://example.com/announce?x2%0644":  "http://example.com/scrape?x2%0644",
+		"http://example.com/announce?key=abc":  "http://example.com/scrape?key=abc",
+		"https://example.com:8443/a/announce":  "https://example.com:8443/a/scrape",
+		"http://example.com/x%064announce":     "",
+		"http://example.com/a":                 "",
+		"http://example.com/announce/":         "",
+		"http://example.com/announce/announce": "http://example.com/announce/scrape",
+	}
+	for announceUrl, expected := range tests {
+		actual, err := ScrapeUrl(announceUrl)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error for %v, got %v", announceUrl, actual)
+			}
+			continue
+		}
+		if err != nil || actual != expected {
+			t.Errorf("For %v expected %v, Actual %v, %v", announceUrl, expected, actual, err)
+		}
+	}
+}
+
+func TestTrackerScrapeHTTP(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("passkey") != "abc" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		response := "d5:filesd"
+		for _, h := range r.URL.Query()["info_hash"] {
+			if h == "unknownunknownunknow" {
+				continue
+			}
+			response += fmt.Sprintf("%v:%vd8:completei%ve10:downloadedi50e10:incompletei10e4:name3:fooe",
+				len(h), h, int(h[0]))
+		}
+		response += "ee"
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	var infoHashes []InfoHash
+	for i := 0; i < 60; i++ {
+		infoHashes = append(infoHashes, InfoHash(string([]byte{byte(i)})+"aaaaaaaaaaaaaaaaaaa"))
+	}
+	unknown := InfoHash("unknownunknownunknow")
+	infoHashes = append(infoHashes, unknown)
+	stats, err := NewTracker(server.URL+"/announce?passkey=abc").Scrape(context.Background(), infoHashes...)
+	if err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %v", requests)
+	}
+	if len(stats) != 60 {
+		t.Errorf("Expected 60 stats, got %v", len(stats))
+	}
+	if _, ok := stats[unknown]; ok {
+		t.Errorf("Unexpected stats for unknown torrent")
+	}
+	expected := ScrapeStats{Complete: 42, Downloaded: 50, Incomplete: 10}
+	if stats[infoHashes[42]] != expected {
+		t.Errorf("Expected %v, Actual %v", expected, stats[infoHashes[42]])
+	}
+
+	_, err = NewTracker(server.URL+"/a").Scrape(context.Background(), infoHashes...)
+	if err == nil {
+		t.Errorf("Expected an error scraping a tracker without a scrape url")
+	}
+}
+
+func TestTrackerScrapeFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason16:scrape forbiddene"))
+	}))
+	defer server.Close()
+
+	_, err := NewTracker(server.URL+"/announce").Scrape(context.Background(), InfoHash("aaaaaaaaaaaaaaaaaaaa"))
+	if failure, ok := err.(*TrackerFailure); !ok || failure.Reason != "scrape forbidden" {
+		t.Errorf("Expected failure, got %v", err)
+	}
+}
+
+func TestTrackerScrapeTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d5:filesd20:aaaaaaaaaaaaaaaaaaaad8:completei5e4:name100:fooeee"))
+	}))
+	defer server.Close()
+
+	if stats, err := NewTracker(server.URL+"/announce").Scrape(context.Background(), InfoHash("aaaaaaaaaaaaaaaaaaaa")); err == nil {
+		t.Errorf("Expected an error for a truncated response, got %v", stats)
+	}
+}
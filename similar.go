@@ -0,0 +1,264 @@
+package gotorrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// FileMatch is a file in a new torrent whose content is already on disk as part of a
+// similar torrent.
+type FileMatch struct {
+	File       int // Index into the new torrent's FileList()
+	Source     InfoHash
+	SourceFile int // Index into the similar torrent's FileList()
+}
+
+// SimilarIndex keeps track of the torrents on disk so that files can be shared between
+// similar torrents (BEP 38), rather than downloaded again.  Torrents are similar if either
+// lists the other's info hash in Similar, or they have a collection in common.
+// Files are identical if they have the same length and SHA1, or if they start on a piece
+// boundary in both torrents and are covered by the same piece hashes.
+// A SimilarIndex is safe for concurrent use.
+type SimilarIndex struct {
+	mu       sync.Mutex
+	torrents map[InfoHash]*similarTorrent
+	// files maps file fingerprints to the files which have them.
+	files map[string][]FileMatch
+}
+
+type similarTorrent struct {
+	metaInfo *MetaInfo
+	store    *FileStore
+}
+
+// NewSimilarIndex returns an empty SimilarIndex.
+func NewSimilarIndex() *SimilarIndex {
+	return &SimilarIndex{
+		torrents: map[InfoHash]*similarTorrent{},
+		files:    map[string][]FileMatch{},
+	}
+}
+
+// Add records that the files of metaInfo are stored in store.
+func (x *SimilarIndex) Add(metaInfo *MetaInfo, store *FileStore) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.torrents[metaInfo.InfoHash]; ok {
+		return
+	}
+	x.torrents[metaInfo.InfoHash] = &similarTorrent{metaInfo, store}
+	for i, fingerprints := range fileFingerprints(&metaInfo.Info) {
+		for _, fingerprint := range fingerprints {
+			x.files[fingerprint] = append(x.files[fingerprint],
+				FileMatch{SourceFile: i, Source: metaInfo.InfoHash})
+		}
+	}
+}
+
+// Matches returns the files of metaInfo which are identical to files in similar torrents
+// in the index.
+func (x *SimilarIndex) Matches(metaInfo *MetaInfo) []FileMatch {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var matches []FileMatch
+	for i, fingerprints := range fileFingerprints(&metaInfo.Info) {
+		found := false
+		for _, fingerprint := range fingerprints {
+			for _, candidate := range x.files[fingerprint] {
+				source := x.torrents[candidate.Source].metaInfo
+				if candidate.Source == metaInfo.InfoHash || !x.similar(metaInfo, source) {
+					continue
+				}
+				candidate.File = i
+				matches = append(matches, candidate)
+				found = true
+				break
+			}
+			if found {
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// similar reports whether a and b are related by BEP 38.  x.mu must be held.
+func (x *SimilarIndex) similar(a, b *MetaInfo) bool {
+	for _, h := range a.Info.Similar {
+		if h == b.InfoHash {
+			return true
+		}
+	}
+	for _, h := range b.Info.Similar {
+		if h == a.InfoHash {
+			return true
+		}
+	}
+	for _, c := range a.Info.Collections {
+		for _, d := range b.Info.Collections {
+			if c == d {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reuse copies the files of metaInfo which match files in similar torrents into store,
+// hard linking them where possible, then records the pieces they complete.  Only files
+// whose content verifies against metaInfo are reused.
+// The torrent is then added to the index.  It returns the files which were reused.
+func (x *SimilarIndex) Reuse(metaInfo *MetaInfo, store *FileStore) ([]FileMatch, error) {
+	var reused []FileMatch
+	isReused := map[int]bool{}
+	for _, match := range x.Matches(metaInfo) {
+		x.mu.Lock()
+		source := x.torrents[match.Source].store
+		x.mu.Unlock()
+		if _, err := os.Lstat(store.FilePath(match.File)); err == nil {
+			// Don't clobber a file which is already being downloaded.
+			continue
+		}
+		sourcePath := source.FilePath(match.SourceFile)
+		if !verifyFile(&metaInfo.Info, match.File, sourcePath) {
+			continue
+		}
+		if err := linkOrCopy(sourcePath, store.FilePath(match.File)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return reused, err
+		}
+		reused = append(reused, match)
+		isReused[match.File] = true
+	}
+
+	files := metaInfo.Info.FileList()
+	for index := 0; index < metaInfo.Info.NumPieces(); index++ {
+		complete := true
+		for _, span := range metaInfo.Info.PieceSpans(index) {
+			if !isReused[span.File] && !files[span.File].IsPadding() {
+				complete = false
+			}
+		}
+		if !complete || store.HasPiece(index) {
+			continue
+		}
+		data, err := store.ReadPiece(index)
+		if err == nil && metaInfo.Info.VerifyPiece(index, data) {
+			store.markHave(index)
+		}
+	}
+	x.Add(metaInfo, store)
+	return reused, nil
+}
+
+// fileFingerprints returns strings identifying the content of each file in info's
+// FileList.  Files with equal fingerprints have the same content.
+func fileFingerprints(info *Info) [][]string {
+	files := info.FileList()
+	fingerprints := make([][]string, len(files))
+	start := 0
+	for i, file := range files {
+		if file.IsPadding() || file.IsSymlink() || file.Length == 0 {
+			start += file.Length
+			continue
+		}
+		length := strconv.Itoa(file.Length)
+		if file.Sha1 != "" {
+			fingerprints[i] = append(fingerprints[i], "sha1:"+length+":"+file.Sha1)
+		}
+		// The last piece of the file may only be compared if nothing but padding follows
+		// the file in it.
+		end := start + file.Length
+		closed := end%info.PieceLength == 0 || end == info.TotalLength()
+		for j := i + 1; !closed && j < len(files) && files[j].IsPadding(); j++ {
+			if (end+files[j].Length)%info.PieceLength == 0 || end+files[j].Length == info.TotalLength() {
+				closed = true
+			}
+			end += files[j].Length
+		}
+		if start%info.PieceLength == 0 && closed {
+			first := start / info.PieceLength
+			last := (start + file.Length - 1) / info.PieceLength
+			fingerprints[i] = append(fingerprints[i], fmt.Sprintf("pieces:%v:%v:%x",
+				info.PieceLength, length, info.Pieces[first*sha1.Size:(last+1)*sha1.Size]))
+		}
+		start += file.Length
+	}
+	return fingerprints
+}
+
+// verifyFile checks that the file at path has the content of the file at index in info's
+// FileList, by its sha1 if there is one, otherwise by the pieces it fills.
+func verifyFile(info *Info, index int, path string) bool {
+	files := info.FileList()
+	file := files[index]
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if stat, err := f.Stat(); err != nil || stat.Size() != int64(file.Length) {
+		return false
+	}
+	if file.Sha1 != "" {
+		hash := sha1.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return false
+		}
+		return string(hash.Sum(nil)) == file.Sha1
+	}
+	start := 0
+	for _, previous := range files[:index] {
+		start += previous.Length
+	}
+	if start%info.PieceLength != 0 {
+		return false
+	}
+	end := start + file.Length
+	for piece := start / info.PieceLength; piece*info.PieceLength < end; piece++ {
+		// Anything after the end of the file in its last piece is padding.
+		data := make([]byte, info.PieceSize(piece))
+		n := len(data)
+		if remaining := end - piece*info.PieceLength; remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(f, data[:n]); err != nil || !info.VerifyPiece(piece, data) {
+			return false
+		}
+	}
+	return true
+}
+
+// linkOrCopy makes the file at dst have the same contents as src.
+func linkOrCopy(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
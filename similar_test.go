@@ -0,0 +1,114 @@
+package gotorrent
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// createTestMetaInfo creates a torrent of the directory at path.
+func createTestMetaInfo(t *testing.T, path string, options CreateOptions) *MetaInfo {
+	info, err := CreateInfo(path, options)
+	if err != nil {
+		t.Fatalf("Error creating info for %v: %v", path, err)
+	}
+	b, err := info.Bencode()
+	if err != nil {
+		t.Fatalf("Error encoding info for %v: %v", path, err)
+	}
+	hash := sha1.Sum([]byte(b))
+	return &MetaInfo{Info: *info, InfoHash: InfoHash(hash[:])}
+}
+
+func TestSimilarIndexReuse(t *testing.T) {
+	shared := strings.Repeat("shared data ", 10)
+	src := t.TempDir()
+	writeTestFiles(t, filepath.Join(src, "a"), []testFile{
+		{[]string{"1.txt"}, "first torrent only"},
+		{[]string{"shared.txt"}, shared},
+	})
+	writeTestFiles(t, filepath.Join(src, "b"), []testFile{
+		{[]string{"2.txt"}, "second torrent only, a bit longer"},
+		{[]string{"shared.txt"}, shared},
+	})
+	options := CreateOptions{PieceLength: 16, AlignFiles: true}
+	a := createTestMetaInfo(t, filepath.Join(src, "a"), options)
+	b := createTestMetaInfo(t, filepath.Join(src, "b"), options)
+	unrelated := *b
+	unrelated.InfoHash = "unrelated"
+
+	index := NewSimilarIndex()
+	index.Add(a, NewFileStore(src, &a.Info))
+	if matches := index.Matches(&unrelated); len(matches) != 0 {
+		t.Errorf("Expected no matches for unrelated torrent, Actual %v", matches)
+	}
+
+	a.Info.Collections = []string{"datasets"}
+	b.Info.Collections = []string{"datasets"}
+	// The shared file is the last file of both torrents, after a padding file.
+	expected := []FileMatch{{File: 2, Source: a.InfoHash, SourceFile: 2}}
+	if matches := index.Matches(b); !reflect.DeepEqual(expected, matches) {
+		t.Errorf("Expected %v, Actual %v", expected, matches)
+	}
+
+	dest := NewFileStore(t.TempDir(), &b.Info)
+	reused, err := index.Reuse(b, dest)
+	if err != nil {
+		t.Fatalf("Error reusing files: %v", err)
+	}
+	if !reflect.DeepEqual(expected, reused) {
+		t.Errorf("Expected %v, Actual %v", expected, reused)
+	}
+	for i := 0; i < b.Info.NumPieces(); i++ {
+		spans := b.Info.PieceSpans(i)
+		expectHave := spans[0].File == 2
+		if dest.HasPiece(i) != expectHave {
+			t.Errorf("Piece %v: Expected HasPiece %v", i, expectHave)
+		}
+	}
+
+	// Without the per-file hashes, the piece hashes are enough to match.
+	a.Info.Files[2].Sha1 = ""
+	b.Info.Files[2].Sha1 = ""
+	index = NewSimilarIndex()
+	b.Info.Collections = nil
+	b.Info.Similar = []InfoHash{a.InfoHash}
+	index.Add(a, NewFileStore(src, &a.Info))
+	if matches := index.Matches(b); !reflect.DeepEqual(expected, matches) {
+		t.Errorf("Expected %v, Actual %v", expected, matches)
+	}
+
+	// A source file which has changed since it was added isn't reused.
+	corrupt := strings.Repeat("SHARED DATA ", 10)
+	writeTestFiles(t, filepath.Join(src, "a"), []testFile{{[]string{"shared.txt"}, corrupt}})
+	dest = NewFileStore(t.TempDir(), &b.Info)
+	if reused, err := index.Reuse(b, dest); err != nil || len(reused) != 0 {
+		t.Errorf("Expected nothing to be reused, got %v, %v", reused, err)
+	}
+	if _, err := os.Lstat(dest.FilePath(2)); !os.IsNotExist(err) {
+		t.Errorf("Expected the corrupt file not to be linked, got %v", err)
+	}
+	if dest.Bitfield().Count() != 0 {
+		t.Errorf("Expected no pieces, got %v", dest.Bitfield())
+	}
+}
+
+func TestMetaInfoCollections(t *testing.T) {
+	input := "d4:infod11:collectionsl1:a1:be6:lengthi1e4:name1:n12:piece lengthi1e6:pieces20:" +
+		strings.Repeat("p", 20) + "7:similarl20:" + strings.Repeat("h", 20) + "eee"
+	metaInfo, err := LoadMetaInfo(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unable to load %v: %v", input, err)
+	}
+	if !reflect.DeepEqual(metaInfo.Info.Collections, []string{"a", "b"}) ||
+		!reflect.DeepEqual(metaInfo.Info.Similar, []InfoHash{InfoHash(strings.Repeat("h", 20))}) {
+		t.Errorf("Unexpected collections %v, similar %v", metaInfo.Info.Collections, metaInfo.Info.Similar)
+	}
+	b, err := metaInfo.Bytes()
+	if err != nil || b != input {
+		t.Errorf("Expected %q, Actual %q, err %v", input, b, err)
+	}
+}
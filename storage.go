@@ -0,0 +1,195 @@
+package gotorrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/optimality/gotorrent/bitfield"
+)
+
+// PieceStore is where verified pieces end up, regardless of whether they were downloaded
+// from peers or web seeds.
+type PieceStore interface {
+	// WritePiece stores the data for the piece at index.  The data must already have been
+	// verified against the piece hash.
+	WritePiece(index int, data []byte) error
+	// HasPiece reports whether the piece at index has been stored.
+	HasPiece(index int) bool
+}
+
+// FileStore is a PieceStore which writes pieces to the torrent's files in a directory.
+type FileStore struct {
+	dir      string
+	info     *Info
+	paths    [][]string
+	rewrites []PathRewrite
+
+	mu   sync.Mutex
+	have *bitfield.Bitfield
+}
+
+// NewFileStore returns a FileStore which stores the files of info under dir, using the
+// default PathMapper to keep them inside dir.
+func NewFileStore(dir string, info *Info) *FileStore {
+	return NewFileStoreWithMapper(dir, info, PathMapper{})
+}
+
+// NewFileStoreWithMapper returns a FileStore which stores the files of info under dir,
+// using mapper to decide where each file goes.
+func NewFileStoreWithMapper(dir string, info *Info, mapper PathMapper) *FileStore {
+	paths, rewrites := mapper.Map(info)
+	return &FileStore{
+		dir:      dir,
+		info:     info,
+		paths:    paths,
+		rewrites: rewrites,
+		have:     info.NewBitfield(),
+	}
+}
+
+// FilePath returns the path on disk of the file at index in the torrent's FileList.
+func (s *FileStore) FilePath(index int) string {
+	return filepath.Join(append([]string{s.dir}, s.paths[index]...)...)
+}
+
+// Rewrites returns the files whose paths had to be changed to be safe to use on disk.
+func (s *FileStore) Rewrites() []PathRewrite {
+	return s.rewrites
+}
+
+func (s *FileStore) WritePiece(index int, data []byte) error {
+	if index < 0 || index >= s.have.Len() {
+		return fmt.Errorf("Invalid piece index %v", index)
+	}
+	if len(data) != s.info.PieceSize(index) {
+		return fmt.Errorf("Wrong length %v for piece %v, expected %v",
+			len(data), index, s.info.PieceSize(index))
+	}
+	files := s.info.FileList()
+	for _, span := range s.info.PieceSpans(index) {
+		if err := s.writeSpan(span, files[span.File], data[:span.Length]); err != nil {
+			return err
+		}
+		data = data[span.Length:]
+	}
+	s.markHave(index)
+	return nil
+}
+
+func (s *FileStore) writeSpan(span FileSpan, file File, data []byte) error {
+	if file.IsPadding() || file.IsSymlink() {
+		return nil
+	}
+	path := s.FilePath(span.File)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if file.IsExecutable() {
+		mode = 0755
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, int64(span.Offset))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReadPiece reads the data for the piece at index from disk.  The data is not verified.
+func (s *FileStore) ReadPiece(index int) ([]byte, error) {
+	if index < 0 || index >= s.have.Len() {
+		return nil, fmt.Errorf("Invalid piece index %v", index)
+	}
+	files := s.info.FileList()
+	data := make([]byte, 0, s.info.PieceSize(index))
+	for _, span := range s.info.PieceSpans(index) {
+		spanData := make([]byte, span.Length)
+		if !files[span.File].IsPadding() {
+			if err := s.readSpan(span, spanData); err != nil {
+				return nil, err
+			}
+		}
+		data = append(data, spanData...)
+	}
+	return data, nil
+}
+
+func (s *FileStore) readSpan(span FileSpan, data []byte) error {
+	f, err := os.Open(s.FilePath(span.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(data, int64(span.Offset))
+	return err
+}
+
+// CreateFiles creates the symlinks in the torrent and sets the executable bit on
+// executable files which already exist.  Files holding data are created as their pieces
+// are written.
+func (s *FileStore) CreateFiles() error {
+	root := filepath.Join(s.dir, s.paths[0][0])
+	files := s.info.FileList()
+	for i, file := range files {
+		path := s.FilePath(i)
+		switch {
+		case file.IsPadding():
+		case file.IsSymlink():
+			target := filepath.Join(append([]string{root}, file.SymlinkPath...)...)
+			// Point the link at the mapped path of its target if the target is a file.
+			for j, other := range files {
+				if strings.Join(other.Path[1:], "/") == strings.Join(file.SymlinkPath, "/") {
+					target = s.FilePath(j)
+				}
+			}
+			if rel, err := filepath.Rel(root, target); err != nil || !filepath.IsLocal(rel) {
+				return fmt.Errorf("Symlink %v points outside the torrent: %v", path, file.SymlinkPath)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			linkTarget, err := filepath.Rel(filepath.Dir(path), target)
+			if err != nil {
+				return err
+			}
+			if existing, err := os.Readlink(path); err == nil && existing == linkTarget {
+				continue
+			}
+			if err := os.Symlink(linkTarget, path); err != nil {
+				return err
+			}
+		case file.IsExecutable():
+			if err := os.Chmod(path, 0755); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// markHave records that the piece at index is stored and verified.
+func (s *FileStore) markHave(index int) {
+	s.mu.Lock()
+	s.have.Set(index)
+	s.mu.Unlock()
+}
+
+func (s *FileStore) HasPiece(index int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.have.Get(index)
+}
+
+// Bitfield returns a copy of the pieces that have been stored.
+func (s *FileStore) Bitfield() *bitfield.Bitfield {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.have.Clone()
+}
@@ -0,0 +1,206 @@
+package gotorrent
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on the number of peers returned by an announce.
+const (
+	defaultNumwant = 50
+	maxNumwant     = 200
+)
+
+// SwarmStore keeps the peers of each torrent announced to a tracker in memory.  Peers
+// which haven't announced for PeerTimeout are dropped.
+// A SwarmStore is safe for concurrent use.
+type SwarmStore struct {
+	PeerTimeout time.Duration
+
+	mu        sync.Mutex
+	swarms    map[InfoHash]*swarm
+	whitelist map[InfoHash]bool
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+}
+
+type swarm struct {
+	peers      map[string]*swarmPeer // By peer id
+	downloaded int
+}
+
+type swarmPeer struct {
+	addr     PeerAddr
+	seeder   bool
+	lastSeen time.Time
+}
+
+// NewSwarmStore returns an empty SwarmStore which drops peers after an hour.
+func NewSwarmStore() *SwarmStore {
+	return &SwarmStore{
+		PeerTimeout: time.Hour,
+		swarms:      map[InfoHash]*swarm{},
+		now:         time.Now,
+	}
+}
+
+// SetWhitelist restricts the store to the torrents with the given info hashes.  Announces
+// for other torrents fail.  A nil whitelist allows every torrent.
+func (s *SwarmStore) SetWhitelist(infoHashes []InfoHash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if infoHashes == nil {
+		s.whitelist = nil
+		return
+	}
+	s.whitelist = map[InfoHash]bool{}
+	for _, h := range infoHashes {
+		s.whitelist[h] = true
+	}
+	for h := range s.swarms {
+		if !s.whitelist[h] {
+			delete(s.swarms, h)
+		}
+	}
+}
+
+// Allowed reports whether the store tracks the torrent with infoHash.
+func (s *SwarmStore) Allowed(infoHash InfoHash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.whitelist == nil || s.whitelist[infoHash]
+}
+
+// Announce records the peer which sent req from ip, and returns up to req.Numwant other
+// peers in the swarm along with the swarm's statistics.
+func (s *SwarmStore) Announce(req TrackerRequest, ip net.IP) ([]PeerAddr, ScrapeStats, error) {
+	if len(req.InfoHash) != 20 {
+		return nil, ScrapeStats{}, fmt.Errorf("Invalid info hash")
+	}
+	if len(req.PeerId) != 20 {
+		return nil, ScrapeStats{}, fmt.Errorf("Invalid peer id")
+	}
+	port, err := strconv.Atoi(req.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, ScrapeStats{}, fmt.Errorf("Invalid port")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.whitelist != nil && !s.whitelist[req.InfoHash] {
+		return nil, ScrapeStats{}, fmt.Errorf("Unregistered torrent")
+	}
+	now := s.now()
+	s.expire(req.InfoHash, now)
+	sw := s.swarms[req.InfoHash]
+	if sw == nil {
+		sw = &swarm{peers: map[string]*swarmPeer{}}
+		s.swarms[req.InfoHash] = sw
+	}
+
+	if req.Event == EventStopped {
+		delete(sw.peers, req.PeerId)
+		if len(sw.peers) == 0 && sw.downloaded == 0 {
+			delete(s.swarms, req.InfoHash)
+		}
+		return nil, sw.stats(), nil
+	}
+	peer := sw.peers[req.PeerId]
+	if peer == nil {
+		peer = &swarmPeer{}
+		sw.peers[req.PeerId] = peer
+	}
+	if req.Event == EventCompleted && !peer.seeder {
+		sw.downloaded++
+	}
+	peer.addr = PeerAddr{ip, port, req.PeerId}
+	peer.seeder = req.Left == 0
+	peer.lastSeen = now
+
+	numwant := req.Numwant
+	if numwant <= 0 {
+		numwant = defaultNumwant
+	}
+	if numwant > maxNumwant {
+		numwant = maxNumwant
+	}
+	// Map iteration order is random, so each announce gets a different selection.
+	var peers []PeerAddr
+	for peerId, other := range sw.peers {
+		if len(peers) >= numwant {
+			break
+		}
+		// Seeders have no use for other seeders.
+		if peerId == req.PeerId || (peer.seeder && other.seeder) {
+			continue
+		}
+		peers = append(peers, other.addr)
+	}
+	return peers, sw.stats(), nil
+}
+
+// Scrape returns the statistics of the torrents with the given info hashes, or of every
+// torrent if none are given.  Torrents with no peers are missing from the result.
+func (s *SwarmStore) Scrape(infoHashes ...InfoHash) map[InfoHash]ScrapeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if len(infoHashes) == 0 {
+		for h := range s.swarms {
+			infoHashes = append(infoHashes, h)
+		}
+	}
+	stats := map[InfoHash]ScrapeStats{}
+	for _, h := range infoHashes {
+		s.expire(h, now)
+		if sw, ok := s.swarms[h]; ok {
+			stats[h] = sw.stats()
+		}
+	}
+	return stats
+}
+
+// Expire drops the peers which have timed out from every swarm.
+func (s *SwarmStore) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for h := range s.swarms {
+		s.expire(h, now)
+	}
+}
+
+// expire drops the peers which have timed out from the swarm for infoHash, and the swarm
+// itself if it is then empty.  s.mu must be held.
+func (s *SwarmStore) expire(infoHash InfoHash, now time.Time) {
+	sw, ok := s.swarms[infoHash]
+	if !ok {
+		return
+	}
+	for peerId, peer := range sw.peers {
+		if now.Sub(peer.lastSeen) > s.PeerTimeout {
+			delete(sw.peers, peerId)
+		}
+	}
+	if len(sw.peers) == 0 && sw.downloaded == 0 {
+		delete(s.swarms, infoHash)
+	}
+}
+
+func (sw *swarm) stats() ScrapeStats {
+	stats := ScrapeStats{Downloaded: sw.downloaded}
+	for _, peer := range sw.peers {
+		if peer.seeder {
+			stats.Complete++
+		} else {
+			stats.Incomplete++
+		}
+	}
+	return stats
+}
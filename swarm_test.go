@@ -0,0 +1,107 @@
+package gotorrent
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func swarmTestRequest(peerId string, left int, event string) TrackerRequest {
+	return TrackerRequest{
+		InfoHash: InfoHash("aaaaaaaaaaaaaaaaaaaa"),
+		PeerId:   peerId + "aaaaaaaaaaaaaaaaaaa",
+		Port:     "6881",
+		Left:     left,
+		Event:    event,
+	}
+}
+
+func TestSwarmStoreAnnounce(t *testing.T) {
+	store := NewSwarmStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ip := net.IPv4(10, 0, 0, 1)
+
+	peers, stats, err := store.Announce(swarmTestRequest("1", 0, EventStarted), ip)
+	if err != nil || len(peers) != 0 || stats != (ScrapeStats{Complete: 1}) {
+		t.Errorf("Unexpected first announce %v, %v, %v", peers, stats, err)
+	}
+	store.Announce(swarmTestRequest("2", 0, EventStarted), ip)
+	peers, stats, _ = store.Announce(swarmTestRequest("3", 100, EventStarted), ip)
+	if len(peers) != 2 || stats != (ScrapeStats{Complete: 2, Incomplete: 1}) {
+		t.Errorf("Unexpected announce %v, %v", peers, stats)
+	}
+	if peers[0].IP.String() != "10.0.0.1" || peers[0].Port != 6881 || len(peers[0].IP) != net.IPv4len {
+		t.Errorf("Unexpected peer %v", peers[0])
+	}
+	// Seeders are only sent leechers.
+	peers, _, _ = store.Announce(swarmTestRequest("1", 0, ""), ip)
+	if len(peers) != 1 || peers[0].PeerId[0] != '3' {
+		t.Errorf("Expected only the leecher, got %v", peers)
+	}
+	req := swarmTestRequest("3", 100, "")
+	req.Numwant = 1
+	if peers, _, _ = store.Announce(req, ip); len(peers) != 1 {
+		t.Errorf("Expected numwant peers, got %v", peers)
+	}
+
+	_, stats, _ = store.Announce(swarmTestRequest("3", 0, EventCompleted), ip)
+	if stats != (ScrapeStats{Complete: 3, Downloaded: 1}) {
+		t.Errorf("Unexpected stats after completed %v", stats)
+	}
+	_, stats, _ = store.Announce(swarmTestRequest("2", 0, EventStopped), ip)
+	if stats != (ScrapeStats{Complete: 2, Downloaded: 1}) {
+		t.Errorf("Unexpected stats after stopped %v", stats)
+	}
+
+	now = now.Add(30 * time.Minute)
+	store.Announce(swarmTestRequest("1", 0, ""), ip)
+	now = now.Add(45 * time.Minute)
+	stats = store.Scrape(InfoHash("aaaaaaaaaaaaaaaaaaaa"))[InfoHash("aaaaaaaaaaaaaaaaaaaa")]
+	if stats != (ScrapeStats{Complete: 1, Downloaded: 1}) {
+		t.Errorf("Expected the idle peer to expire, got %v", stats)
+	}
+}
+
+func TestSwarmStoreRejectsInvalidAnnounces(t *testing.T) {
+	store := NewSwarmStore()
+	ip := net.IPv4(10, 0, 0, 1)
+	for _, modify := range []func(*TrackerRequest){
+		func(req *TrackerRequest) { req.InfoHash = "short" },
+		func(req *TrackerRequest) { req.PeerId = "short" },
+		func(req *TrackerRequest) { req.Port = "x" },
+		func(req *TrackerRequest) { req.Port = "70000" },
+	} {
+		req := swarmTestRequest("1", 0, "")
+		modify(&req)
+		if _, _, err := store.Announce(req, ip); err == nil {
+			t.Errorf("Expected an error for %+v", req)
+		}
+	}
+}
+
+func TestSwarmStoreWhitelist(t *testing.T) {
+	store := NewSwarmStore()
+	ip := net.IPv4(10, 0, 0, 1)
+	store.Announce(swarmTestRequest("1", 0, ""), ip)
+	allowed := InfoHash("bbbbbbbbbbbbbbbbbbbb")
+	store.SetWhitelist([]InfoHash{allowed})
+	if store.Allowed(InfoHash("aaaaaaaaaaaaaaaaaaaa")) || !store.Allowed(allowed) {
+		t.Errorf("Whitelist not applied")
+	}
+	if _, _, err := store.Announce(swarmTestRequest("1", 0, ""), ip); err == nil {
+		t.Errorf("Expected an error for a torrent not on the whitelist")
+	}
+	if stats := store.Scrape(); len(stats) != 0 {
+		t.Errorf("Expected swarms not on the whitelist to be dropped, got %v", stats)
+	}
+	req := swarmTestRequest("1", 0, "")
+	req.InfoHash = allowed
+	if _, _, err := store.Announce(req, ip); err != nil {
+		t.Errorf("Error announcing an allowed torrent: %v", err)
+	}
+	store.SetWhitelist(nil)
+	if !store.Allowed(InfoHash("aaaaaaaaaaaaaaaaaaaa")) {
+		t.Errorf("Expected every torrent to be allowed without a whitelist")
+	}
+}
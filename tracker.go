@@ -0,0 +1,304 @@
+package gotorrent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+// Tracker announces torrents to a single tracker.
+// See https://wiki.theory.org/BitTorrentSpecification#Tracker_HTTP.2FHTTPS_Protocol for
+// details of the protocol.
+type Tracker struct {
+	Url string
+	// Client is used to make requests to HTTP trackers.  If nil, the Transport's client
+	// is used, or http.DefaultClient if there is no Transport.
+	Client *http.Client
+	// Transport holds the settings for HTTP trackers.  It may be nil.
+	Transport *TrackerTransport
+	// UDPTimeout and UDPRetries control retransmission to UDP trackers.  Attempt n waits
+	// UDPTimeout * 2^n for a response, and up to UDPRetries retransmissions are made.
+	// The defaults are the 15 seconds and 8 retransmissions given in BEP 15.
+	UDPTimeout time.Duration
+	UDPRetries int
+
+	// ipVersion restricts connections to "4" or "6" when set.
+	ipVersion string
+
+	// UDP connection IDs may be reused for a minute.
+	mu               sync.Mutex
+	connectionId     uint64
+	connectionIdTime time.Time
+	// families are the trackers AnnounceDualStack uses for each IP version, which are
+	// kept so that their UDP connection IDs are reused.
+	families map[string]*Tracker
+}
+
+// NewTracker returns a Tracker for the tracker at trackerUrl.
+func NewTracker(trackerUrl string) *Tracker {
+	return &Tracker{Url: trackerUrl}
+}
+
+// TrackerFailure is the error returned when a tracker responds with a failure reason.
+type TrackerFailure struct {
+	Url    string
+	Reason string
+}
+
+func (e *TrackerFailure) Error() string {
+	return fmt.Sprintf("Tracker %v failed: %v", e.Url, e.Reason)
+}
+
+func (t *Tracker) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	if t.Transport != nil {
+		return t.Transport.httpClient()
+	}
+	return http.DefaultClient
+}
+
+// Announce sends req to the tracker and returns its response.  If the tracker responds
+// with a failure reason, the error is a *TrackerFailure.
+func (t *Tracker) Announce(ctx context.Context, req TrackerRequest) (*TrackerResponse, error) {
+	trackerUrl, err := url.Parse(t.Url)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse url %v", t.Url)
+	}
+	switch trackerUrl.Scheme {
+	case "http", "https":
+		return t.announceHTTP(ctx, trackerUrl, req)
+	case "udp":
+		return t.announceUDP(ctx, trackerUrl.Host, req)
+	case "ws", "wss":
+		return t.announceWebSocket(ctx, req)
+	default:
+		return nil, fmt.Errorf("Unsupported tracker protocol %v", t.Url)
+	}
+}
+
+func (t *Tracker) announceHTTP(ctx context.Context, trackerUrl *url.URL, req TrackerRequest) (*TrackerResponse, error) {
+	appendQuery(trackerUrl, req.Query())
+
+	resp, body, err := t.get(ctx, trackerUrl.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var trackerResponse TrackerResponse
+	err = bencoding.Unmarshal(string(body), &trackerResponse)
+	if err == nil && trackerResponse.FailureReason != "" {
+		return nil, &TrackerFailure{t.Url, trackerResponse.FailureReason}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Non-200 response from %v: %v", t.Url, resp.Status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse response from %v: %v", t.Url, err)
+	}
+	return &trackerResponse, nil
+}
+
+// AnnounceDualStack announces to the tracker over both IPv4 and IPv6, so that the tracker
+// learns our address in each family (BEP 7), and merges the peers from both responses.
+// It only fails if both announces fail, in which case the IPv4 error is returned.  An HTTP
+// tracker whose client has a custom RoundTripper is announced to once, with that client,
+// since the RoundTripper can't be restricted to one family, as is a WebSocket tracker.
+// The settings of t are copied for each family on the first dual stack announce.
+func (t *Tracker) AnnounceDualStack(ctx context.Context, req TrackerRequest) (*TrackerResponse, error) {
+	if strings.HasPrefix(t.Url, "ws") || (strings.HasPrefix(t.Url, "http") && !t.canPinNetwork()) {
+		return t.Announce(ctx, req)
+	}
+	versions := []string{"4", "6"}
+	responses := make([]*TrackerResponse, len(versions))
+	errs := make([]error, len(versions))
+	var wg sync.WaitGroup
+	for i, version := range versions {
+		wg.Add(1)
+		go func(i int, version string) {
+			defer wg.Done()
+			responses[i], errs[i] = t.family(version).Announce(ctx, req)
+		}(i, version)
+	}
+	wg.Wait()
+
+	switch {
+	case errs[0] != nil && errs[1] != nil:
+		return nil, errs[0]
+	case errs[0] != nil:
+		return responses[1], nil
+	case errs[1] != nil:
+		return responses[0], nil
+	}
+	return mergeTrackerResponses(responses[0], responses[1]), nil
+}
+
+// family returns the tracker which announces to t over IP version.
+func (t *Tracker) family(version string) *Tracker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if family, ok := t.families[version]; ok {
+		return family
+	}
+	family := &Tracker{
+		Url:        t.Url,
+		Client:     t.clientForNetwork("tcp" + version),
+		Transport:  t.Transport,
+		UDPTimeout: t.UDPTimeout,
+		UDPRetries: t.UDPRetries,
+		ipVersion:  version,
+	}
+	if t.families == nil {
+		t.families = map[string]*Tracker{}
+	}
+	t.families[version] = family
+	return family
+}
+
+// canPinNetwork reports whether t's client can be restricted to one network by
+// clientForNetwork.
+func (t *Tracker) canPinNetwork() bool {
+	switch t.client().Transport.(type) {
+	case nil, *http.Transport:
+		return true
+	}
+	return false
+}
+
+// clientForNetwork returns a copy of t's client which only connects over network.  The
+// client's Transport must be nil or an *http.Transport.
+func (t *Tracker) clientForNetwork(network string) *http.Client {
+	client := *t.client()
+	transport, _ := client.Transport.(*http.Transport)
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	client.Transport = transport
+	return &client
+}
+
+// mergeTrackerResponses combines the responses to announces over IPv4 and IPv6.
+func mergeTrackerResponses(a, b *TrackerResponse) *TrackerResponse {
+	merged := *a
+	seen := map[string]bool{}
+	merged.Peers, merged.Peers6 = nil, nil
+	for _, r := range []*TrackerResponse{a, b} {
+		for _, peer := range r.Peers {
+			if !seen[peer.String()] {
+				seen[peer.String()] = true
+				merged.Peers = append(merged.Peers, peer)
+			}
+		}
+		for _, peer := range r.Peers6 {
+			if !seen[peer.String()] {
+				seen[peer.String()] = true
+				merged.Peers6 = append(merged.Peers6, peer)
+			}
+		}
+	}
+	if b.Complete > merged.Complete {
+		merged.Complete = b.Complete
+	}
+	if b.Incomplete > merged.Incomplete {
+		merged.Incomplete = b.Incomplete
+	}
+	if merged.WarningMessage == "" {
+		merged.WarningMessage = b.WarningMessage
+	}
+	return &merged
+}
+
+// PeerAddr is the address of a peer returned by a tracker.
+type PeerAddr struct {
+	IP   net.IP
+	Port int
+	// PeerId is only present in non-compact responses.
+	PeerId string
+}
+
+func (p PeerAddr) String() string {
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(p.Port))
+}
+
+// PeerList is a list of peers, which trackers send either as a list of dicts or as a
+// string of 6 byte entries (BEP 23): a 4 byte IPv4 address followed by a 2 byte port,
+// both in network byte order.
+type PeerList []PeerAddr
+
+func (l *PeerList) UnmarshalBencode(s string) error {
+	if s[0] != 'l' {
+		var compact string
+		if err := bencoding.Unmarshal(s, &compact); err != nil {
+			return err
+		}
+		peers, err := decodeCompactPeers(compact, net.IPv4len)
+		*l = peers
+		return err
+	}
+
+	var dictPeers []struct {
+		PeerId string
+		Ip     string
+		Port   int
+	}
+	if err := bencoding.Unmarshal(s, &dictPeers); err != nil {
+		return err
+	}
+	*l = nil
+	for _, peer := range dictPeers {
+		// The ip may also be a DNS name, which we don't support.
+		ip := net.ParseIP(peer.Ip)
+		if ip == nil || peer.Port <= 0 || peer.Port > 65535 {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		*l = append(*l, PeerAddr{ip, peer.Port, peer.PeerId})
+	}
+	return nil
+}
+
+// Peer6List is a list of IPv6 peers, sent by trackers in the peers6 key as a string of
+// 18 byte entries: a 16 byte IPv6 address followed by a 2 byte port (BEP 7).
+type Peer6List []PeerAddr
+
+func (l *Peer6List) UnmarshalBencode(s string) error {
+	var compact string
+	if err := bencoding.Unmarshal(s, &compact); err != nil {
+		return err
+	}
+	peers, err := decodeCompactPeers(compact, net.IPv6len)
+	*l = Peer6List(peers)
+	return err
+}
+
+// decodeCompactPeers decodes a compact peer list whose addresses are ipLength bytes.
+func decodeCompactPeers(compact string, ipLength int) (PeerList, error) {
+	entryLength := ipLength + 2
+	if len(compact)%entryLength != 0 {
+		return nil, fmt.Errorf("Invalid compact peer list length %v", len(compact))
+	}
+	var peers PeerList
+	for i := 0; i < len(compact); i += entryLength {
+		ip := net.IP([]byte(compact[i : i+ipLength]))
+		port := int(binary.BigEndian.Uint16([]byte(compact[i+ipLength : i+entryLength])))
+		peers = append(peers, PeerAddr{IP: ip, Port: port})
+	}
+	return peers, nil
+}
@@ -0,0 +1,201 @@
+package gotorrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+const (
+	// TrackerExchangeExtension is the name of the tracker exchange extension (BEP 28) in
+	// the extension handshake.
+	TrackerExchangeExtension = "lt_tex"
+
+	// maxTexTrackers is the most trackers sent or accepted in one message.
+	maxTexTrackers = 50
+	// maxTexTrackerLength is the longest tracker url accepted from a peer.
+	maxTexTrackerLength = 512
+	// maxExchangedTrackers limits the trackers learned from peers for one torrent.
+	maxExchangedTrackers = 100
+)
+
+type texMessage struct {
+	Added []string
+}
+
+type texPeer struct {
+	sent         map[string]bool
+	lastSent     time.Time
+	lastReceived time.Time
+}
+
+// TrackerExchange exchanges trackers with the peers of a single torrent, using the
+// lt_tex extension (BEP 28).  Only trackers that have been announced to successfully are
+// advertised, and trackers from peers are added to a tier after the torrent's own.
+// Peers are identified by any string the caller chooses, such as their address.
+// A TrackerExchange is safe for concurrent use.
+type TrackerExchange struct {
+	// Interval is the shortest time between messages to or from a peer.  Messages from a
+	// peer that arrive sooner are rejected.
+	Interval time.Duration
+
+	tiers *TrackerTiers
+	mu    sync.Mutex
+	peers map[string]*texPeer
+	added int
+	now   func() time.Time
+}
+
+// NewTrackerExchange returns a TrackerExchange that adds trackers to tiers.  It fails for
+// torrents that don't allow tracker exchange, such as private torrents.
+func NewTrackerExchange(metaInfo *MetaInfo, tiers *TrackerTiers) (*TrackerExchange, error) {
+	if !metaInfo.AllowsPeerSource(PeerSourceTrackerExchange) {
+		return nil, errors.New("Tracker exchange isn't allowed for this torrent")
+	}
+	return &TrackerExchange{
+		Interval: time.Minute,
+		tiers:    tiers,
+		peers:    map[string]*texPeer{},
+		now:      time.Now,
+	}, nil
+}
+
+func (x *TrackerExchange) peer(peer string) *texPeer {
+	p, ok := x.peers[peer]
+	if !ok {
+		p = &texPeer{sent: map[string]bool{}}
+		x.peers[peer] = p
+	}
+	return p
+}
+
+// trackerListHash returns the SHA-1 of the sorted tracker urls.
+func trackerListHash(trackers []string) string {
+	sorted := append([]string(nil), trackers...)
+	sort.Strings(sorted)
+	h := sha1.Sum([]byte(strings.Join(sorted, "")))
+	return string(h[:])
+}
+
+// TrackerHash returns the value of the "tr" key in the extension handshake, the hash of
+// the trackers that would be advertised.
+func (x *TrackerExchange) TrackerHash() string {
+	return trackerListHash(x.tiers.Working())
+}
+
+// PeerHandshake records the "tr" value from a peer's extension handshake.  If it matches
+// our own, the peer already knows our trackers and they aren't sent.
+func (x *TrackerExchange) PeerHandshake(peer, trackerHash string) {
+	working := x.tiers.Working()
+	if trackerListHash(working) != trackerHash {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p := x.peer(peer)
+	for _, u := range working {
+		p.sent[u] = true
+	}
+}
+
+// Message returns the lt_tex message to send to peer, with the working trackers it hasn't
+// been sent yet.  It returns nil if there are none, or if peer was sent a message within
+// the last Interval.
+func (x *TrackerExchange) Message(peer string) []byte {
+	working := x.tiers.Working()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p := x.peer(peer)
+	now := x.now()
+	if !p.lastSent.IsZero() && now.Sub(p.lastSent) < x.Interval {
+		return nil
+	}
+	var added []string
+	for _, u := range working {
+		if !p.sent[u] && len(added) < maxTexTrackers {
+			added = append(added, u)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	message, err := bencoding.Marshal(bencodeDict{"added": added})
+	if err != nil {
+		return nil
+	}
+	for _, u := range added {
+		p.sent[u] = true
+	}
+	p.lastSent = now
+	return []byte(message)
+}
+
+// Receive handles an lt_tex message from peer, and returns the trackers that were added.
+// Trackers that aren't valid are ignored.
+func (x *TrackerExchange) Receive(peer string, payload []byte) ([]string, error) {
+	var message texMessage
+	if err := bencoding.Unmarshal(string(payload), &message); err != nil {
+		return nil, errors.New("Invalid lt_tex message")
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p := x.peer(peer)
+	now := x.now()
+	if !p.lastReceived.IsZero() && now.Sub(p.lastReceived) < x.Interval {
+		return nil, errors.New("Too many lt_tex messages")
+	}
+	p.lastReceived = now
+
+	var added []string
+	for i, u := range message.Added {
+		if i >= maxTexTrackers || x.added >= maxExchangedTrackers {
+			break
+		}
+		// The peer knows about the tracker, so there's no need to send it back.
+		p.sent[u] = true
+		if validExchangedTracker(u) && x.tiers.Add(u) {
+			x.added++
+			added = append(added, u)
+		}
+	}
+	return added, nil
+}
+
+// RemovePeer forgets peer, once it has disconnected.
+func (x *TrackerExchange) RemovePeer(peer string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.peers, peer)
+}
+
+// validExchangedTracker reports whether trackerUrl may be used.  Since any peer can send
+// trackers, urls that point at local or private hosts are refused.
+func validExchangedTracker(trackerUrl string) bool {
+	if len(trackerUrl) > maxTexTrackerLength {
+		return false
+	}
+	u, err := url.Parse(trackerUrl)
+	if err != nil || u.User != nil || u.Hostname() == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "udp":
+	default:
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsGlobalUnicast() && !ip.IsPrivate()
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package gotorrent
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestTrackerExchange(t *testing.T, trackers ...string) (*TrackerExchange, *TrackerTiers, *time.Time) {
+	var metaInfo MetaInfo
+	metaInfo.Announce_List = [][]string{trackers}
+	tiers := newTrackerTiers(metaInfo, rand.New(rand.NewSource(1)))
+	x, err := NewTrackerExchange(&metaInfo, tiers)
+	if err != nil {
+		t.Fatalf("Couldn't create tracker exchange: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	x.now = func() time.Time { return now }
+	return x, tiers, &now
+}
+
+func TestTrackerExchangeMessage(t *testing.T) {
+	x, tiers, now := newTestTrackerExchange(t, "http://a/announce", "http://b/announce")
+	if message := x.Message("peer"); message != nil {
+		t.Errorf("Expected no message before announcing, got %q", message)
+	}
+	// Only the tracker that responded is advertised.
+	tiers.Announce(func(trackerUrl string) error {
+		if trackerUrl == "http://b/announce" {
+			return nil
+		}
+		return errors.New("down")
+	})
+	expected := "d5:addedl17:http://b/announceee"
+	if message := x.Message("peer"); string(message) != expected {
+		t.Errorf("Expected %q, Actual %q", expected, message)
+	}
+
+	// b was promoted, so it has to fail for a to be tried.
+	tiers.Announce(func(trackerUrl string) error {
+		if trackerUrl == "http://a/announce" {
+			return nil
+		}
+		return errors.New("down")
+	})
+	if message := x.Message("peer"); message != nil {
+		t.Errorf("Expected no message within the interval, got %q", message)
+	}
+	*now = now.Add(time.Minute)
+	expected = "d5:addedl17:http://a/announceee"
+	if message := x.Message("peer"); string(message) != expected {
+		t.Errorf("Expected %q, Actual %q", expected, message)
+	}
+
+	// A peer that already has the same trackers isn't sent any.
+	x.PeerHandshake("other", x.TrackerHash())
+	if message := x.Message("other"); message != nil {
+		t.Errorf("Expected no message for a peer with the same trackers, got %q", message)
+	}
+}
+
+func TestTrackerExchangeReceive(t *testing.T) {
+	x, tiers, now := newTestTrackerExchange(t, "http://a/announce")
+	payload := "d5:addedl" +
+		"17:http://a/announce" +
+		"17:udp://c:6969/anno" +
+		"21:http://127.0.0.1/anno" +
+		"20:http://10.0.0.1/anno" +
+		"21:http://localhost/anno" +
+		"20:ftp://example/announ" +
+		"24:http://user:pw@d/announc" +
+		"20:https://e.org/announ" +
+		"ee"
+	added, err := x.Receive("peer", []byte(payload))
+	if err != nil {
+		t.Fatalf("Error receiving: %v", err)
+	}
+	expected := []string{"udp://c:6969/anno", "https://e.org/announ"}
+	if !reflect.DeepEqual(expected, added) {
+		t.Errorf("Expected %v, Actual %v", expected, added)
+	}
+	expectedTiers := [][]string{{"http://a/announce"}, expected}
+	if actual := tiers.Tiers(); !reflect.DeepEqual(expectedTiers, actual) {
+		t.Errorf("Expected %v, Actual %v", expectedTiers, actual)
+	}
+
+	if _, err := x.Receive("peer", []byte("d5:addedl12:http://f/annee")); err == nil {
+		t.Errorf("Expected an error for a message within the interval")
+	}
+	if _, err := x.Receive("other", []byte("d5:added")); err == nil {
+		t.Errorf("Expected an error for an invalid message")
+	}
+	*now = now.Add(time.Minute)
+	if added, err := x.Receive("peer", []byte("d5:addedl12:http://f/annee")); err != nil || len(added) != 1 {
+		t.Errorf("Unexpected result %v, %v", added, err)
+	}
+
+	// Trackers the peer sent aren't sent back to it.
+	tiers.Announce(func(trackerUrl string) error { return errors.New("down") })
+	tiers.Announce(func(trackerUrl string) error { return nil })
+	if message := x.Message("peer"); message != nil {
+		t.Errorf("Expected no message, got %q", message)
+	}
+}
+
+func TestTrackerExchangeReceiveTruncated(t *testing.T) {
+	x, _, _ := newTestTrackerExchange(t, "http://a/announce")
+	for _, payload := range []string{
+		"d5:addedl100:http://x/ee",
+		"d5:addedl17:http://b/annee",
+		"d5:addedl-1:http://x/ee",
+		"d5:added999999999999:",
+	} {
+		if added, err := x.Receive("peer", []byte(payload)); err == nil {
+			t.Errorf("%q: Expected an error, got %v", payload, added)
+		}
+	}
+}
+
+func TestTrackerExchangePrivate(t *testing.T) {
+	var metaInfo MetaInfo
+	metaInfo.Info.Private = 1
+	if _, err := NewTrackerExchange(&metaInfo, NewTrackerTiers(metaInfo)); err == nil {
+		t.Errorf("Expected an error for a private torrent")
+	}
+}
@@ -0,0 +1,311 @@
+package gotorrent
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/optimality/gotorrent/bencoding"
+)
+
+// TrackerServer is a BitTorrent tracker.  It serves announce and scrape requests over HTTP
+// at any path ending in /announce or /scrape, and over UDP (BEP 15) with ServeUDP.  Both
+// share the peers in Store.  WebSocket connections to any path are served as a WebTorrent
+// tracker, whose browser peers are kept apart from Store since they can't be reached
+// directly; only Store's whitelist applies to them.
+type TrackerServer struct {
+	Store *SwarmStore
+	// Interval and MinInterval are sent to clients in announce responses.
+	Interval    time.Duration
+	MinInterval time.Duration
+	// WebSocketInterval is the announce interval for WebSocket peers, which is shorter
+	// since browsers come and go.
+	WebSocketInterval time.Duration
+
+	// secret makes UDP connection IDs hard to guess.
+	secret []byte
+
+	// lastExpire is when peers which timed out were last dropped from Store.
+	expireMu   sync.Mutex
+	lastExpire time.Time
+
+	wsMu     sync.Mutex
+	wsSwarms map[InfoHash]*wsSwarm
+}
+
+// NewTrackerServer returns a TrackerServer which keeps its peers in store.
+func NewTrackerServer(store *SwarmStore) *TrackerServer {
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return &TrackerServer{
+		Store:             store,
+		Interval:          30 * time.Minute,
+		MinInterval:       time.Minute,
+		WebSocketInterval: 2 * time.Minute,
+		secret:            secret,
+		wsSwarms:          map[InfoHash]*wsSwarm{},
+	}
+}
+
+// expireInterval is how often the tracker drops peers which have timed out from every
+// swarm, including those which get no more announces.
+const expireInterval = time.Minute
+
+// expirePeers drops the peers which have timed out from Store, if it hasn't been done in
+// the last expireInterval.
+func (s *TrackerServer) expirePeers() {
+	now := s.Store.now()
+	s.expireMu.Lock()
+	if now.Sub(s.lastExpire) < expireInterval {
+		s.expireMu.Unlock()
+		return
+	}
+	s.lastExpire = now
+	s.expireMu.Unlock()
+	s.Store.Expire()
+}
+
+func (s *TrackerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.expirePeers()
+	if isWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r)
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "announce":
+		s.serveAnnounce(w, r)
+	case "scrape":
+		s.serveScrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *TrackerServer) serveAnnounce(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := TrackerRequest{
+		InfoHash: InfoHash(query.Get("info_hash")),
+		PeerId:   query.Get("peer_id"),
+		Port:     query.Get("port"),
+		Compact:  query.Get("compact") != "0",
+		NoPeerId: query.Get("no_peer_id") == "1",
+		Event:    query.Get("event"),
+	}
+	for key, value := range map[string]*int{
+		"uploaded":   &req.Uploaded,
+		"downloaded": &req.Downloaded,
+		"left":       &req.Left,
+		"numwant":    &req.Numwant,
+	} {
+		if query.Get(key) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(query.Get(key))
+		if err != nil {
+			writeTrackerFailure(w, "Invalid "+key)
+			return
+		}
+		*value = n
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil {
+		writeTrackerFailure(w, "Invalid address")
+		return
+	}
+
+	peers, stats, err := s.Store.Announce(req, ip)
+	if err != nil {
+		writeTrackerFailure(w, err.Error())
+		return
+	}
+	response := bencodeDict{
+		"interval":     int(s.Interval / time.Second),
+		"min interval": int(s.MinInterval / time.Second),
+		"complete":     stats.Complete,
+		"incomplete":   stats.Incomplete,
+	}
+	if req.Compact {
+		response["peers"] = encodeCompactPeers(peers, net.IPv4len)
+		peers6 := encodeCompactPeers(peers, net.IPv6len)
+		response.set("peers6", peers6, peers6 == "")
+	} else {
+		dictPeers := make([]bencodeDict, len(peers))
+		for i, peer := range peers {
+			dictPeers[i] = bencodeDict{"ip": peer.IP.String(), "port": peer.Port}
+			dictPeers[i].set("peer id", peer.PeerId, req.NoPeerId)
+		}
+		response["peers"] = dictPeers
+	}
+	writeBencode(w, response)
+}
+
+func (s *TrackerServer) serveScrape(w http.ResponseWriter, r *http.Request) {
+	var infoHashes []InfoHash
+	for _, h := range r.URL.Query()["info_hash"] {
+		infoHashes = append(infoHashes, InfoHash(h))
+	}
+	writeBencode(w, bencodeDict{"files": s.Store.Scrape(infoHashes...)})
+}
+
+func writeTrackerFailure(w http.ResponseWriter, reason string) {
+	writeBencode(w, bencodeDict{"failure reason": reason})
+}
+
+func writeBencode(w http.ResponseWriter, v interface{}) {
+	response, err := bencoding.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+// encodeCompactPeers returns the compact encoding of the peers whose addresses are
+// ipLength bytes.
+func encodeCompactPeers(peers []PeerAddr, ipLength int) string {
+	var compact []byte
+	for _, peer := range peers {
+		ip := peer.IP.To4()
+		if ipLength == net.IPv6len {
+			if ip != nil {
+				continue
+			}
+			ip = peer.IP.To16()
+		}
+		if len(ip) != ipLength {
+			continue
+		}
+		compact = append(compact, ip...)
+		compact = binary.BigEndian.AppendUint16(compact, uint16(peer.Port))
+	}
+	return string(compact)
+}
+
+// ServeUDP answers UDP tracker requests received on conn until reading from it fails,
+// for instance because it was closed, and returns the error.
+func (s *TrackerServer) ServeUDP(conn net.PacketConn) error {
+	buffer := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < 16 {
+			continue
+		}
+		s.expirePeers()
+		if response := s.udpResponse(buffer[:n], udpAddr); response != nil {
+			conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// udpResponse returns the response to a UDP tracker request from addr, or nil if the
+// request should be ignored.
+func (s *TrackerServer) udpResponse(packet []byte, addr *net.UDPAddr) []byte {
+	connectionId := binary.BigEndian.Uint64(packet[0:8])
+	action := binary.BigEndian.Uint32(packet[8:12])
+	response := append([]byte{}, packet[8:16]...)
+	if action == udpActionConnect {
+		if connectionId != udpProtocolId {
+			return nil
+		}
+		return binary.BigEndian.AppendUint64(response, s.connectionId(addr, time.Now()))
+	}
+	if connectionId != s.connectionId(addr, time.Now()) &&
+		connectionId != s.connectionId(addr, time.Now().Add(-udpConnectionIdLifetime)) {
+		return udpErrorResponse(response, "Invalid connection id")
+	}
+
+	switch action {
+	case udpActionAnnounce:
+		req, err := parseUDPAnnounce(packet)
+		if err != nil {
+			return udpErrorResponse(response, err.Error())
+		}
+		peers, stats, err := s.Store.Announce(req, addr.IP)
+		if err != nil {
+			return udpErrorResponse(response, err.Error())
+		}
+		response = binary.BigEndian.AppendUint32(response, uint32(s.Interval/time.Second))
+		response = binary.BigEndian.AppendUint32(response, uint32(stats.Incomplete))
+		response = binary.BigEndian.AppendUint32(response, uint32(stats.Complete))
+		// Clients can only tell the address family of the peers from the family of the
+		// tracker's address.
+		ipLength := net.IPv6len
+		if addr.IP.To4() != nil {
+			ipLength = net.IPv4len
+		}
+		return append(response, encodeCompactPeers(peers, ipLength)...)
+	case udpActionScrape:
+		var infoHashes []InfoHash
+		for i := 16; i+20 <= len(packet) && len(infoHashes) < maxUDPScrapeSize; i += 20 {
+			infoHashes = append(infoHashes, InfoHash(packet[i:i+20]))
+		}
+		if len(infoHashes) == 0 {
+			return udpErrorResponse(response, "No info hashes")
+		}
+		stats := s.Store.Scrape(infoHashes...)
+		for _, h := range infoHashes {
+			response = binary.BigEndian.AppendUint32(response, uint32(stats[h].Complete))
+			response = binary.BigEndian.AppendUint32(response, uint32(stats[h].Downloaded))
+			response = binary.BigEndian.AppendUint32(response, uint32(stats[h].Incomplete))
+		}
+		return response
+	default:
+		return udpErrorResponse(response, "Unknown action")
+	}
+}
+
+// connectionId returns the connection ID for addr in the minute containing t.  IDs are
+// derived from a secret rather than stored, so they cost nothing to hand out.  They only
+// depend on the IP address, since clients may send each request from a new port.
+func (s *TrackerServer) connectionId(addr *net.UDPAddr, t time.Time) uint64 {
+	h := sha1.New()
+	h.Write(s.secret)
+	h.Write(addr.IP.To16())
+	binary.Write(h, binary.BigEndian, t.Unix()/int64(udpConnectionIdLifetime/time.Second))
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+func parseUDPAnnounce(packet []byte) (TrackerRequest, error) {
+	if len(packet) < 98 {
+		return TrackerRequest{}, errors.New("Short announce")
+	}
+	var event string
+	found := false
+	for name, value := range udpEvents {
+		if value == binary.BigEndian.Uint32(packet[80:84]) {
+			event, found = name, true
+		}
+	}
+	if !found {
+		return TrackerRequest{}, errors.New("Invalid event")
+	}
+	return TrackerRequest{
+		InfoHash:   InfoHash(packet[16:36]),
+		PeerId:     string(packet[36:56]),
+		Downloaded: int(binary.BigEndian.Uint64(packet[56:64])),
+		Left:       int(binary.BigEndian.Uint64(packet[64:72])),
+		Uploaded:   int(binary.BigEndian.Uint64(packet[72:80])),
+		Event:      event,
+		Numwant:    int(int32(binary.BigEndian.Uint32(packet[92:96]))),
+		Port:       strconv.Itoa(int(binary.BigEndian.Uint16(packet[96:98]))),
+		Compact:    true,
+	}, nil
+}
+
+func udpErrorResponse(header []byte, message string) []byte {
+	binary.BigEndian.PutUint32(header, udpActionError)
+	return append(header, message...)
+}
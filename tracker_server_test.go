@@ -0,0 +1,168 @@
+package gotorrent
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrackerServerHTTP(t *testing.T) {
+	store := NewSwarmStore()
+	server := NewTrackerServer(store)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	tracker := NewTracker(httpServer.URL + "/announce")
+
+	infoHash := InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	store.Announce(TrackerRequest{InfoHash: infoHash, PeerId: "bbbbbbbbbbbbbbbbbbbb", Port: "80", Left: 100},
+		net.ParseIP("2001:db8::1"))
+	store.Announce(TrackerRequest{InfoHash: infoHash, PeerId: "cccccccccccccccccccc", Port: "81", Left: 100},
+		net.IPv4(10, 0, 0, 1))
+
+	req := TestTrackerRequest
+	req.InfoHash = infoHash
+	req.Compact = true
+	resp, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if resp.Interval != 1800 || resp.MinInterval != 60 || resp.Complete != 1 || resp.Incomplete != 2 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0].String() != "10.0.0.1:81" {
+		t.Errorf("Unexpected peers %v", resp.Peers)
+	}
+	if len(resp.Peers6) != 1 || resp.Peers6[0].String() != "[2001:db8::1]:80" {
+		t.Errorf("Unexpected peers6 %v", resp.Peers6)
+	}
+
+	req.Compact = false
+	req.Left = 100
+	resp, err = tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if len(resp.Peers) != 2 || len(resp.Peers6) != 0 {
+		t.Fatalf("Unexpected peers %v %v", resp.Peers, resp.Peers6)
+	}
+	for _, peer := range resp.Peers {
+		if len(peer.PeerId) != 20 {
+			t.Errorf("Expected a peer id for %v", peer)
+		}
+	}
+	req.NoPeerId = true
+	resp, _ = tracker.Announce(context.Background(), req)
+	if len(resp.Peers) != 2 || resp.Peers[0].PeerId != "" {
+		t.Errorf("Unexpected peers %v", resp.Peers)
+	}
+
+	stats, err := tracker.Scrape(context.Background(), infoHash, InfoHash("unknownunknownunknow"))
+	if err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	if len(stats) != 1 || stats[infoHash] != (ScrapeStats{Incomplete: 3}) {
+		t.Errorf("Unexpected scrape %v", stats)
+	}
+
+	store.SetWhitelist([]InfoHash{})
+	_, err = tracker.Announce(context.Background(), req)
+	if failure, ok := err.(*TrackerFailure); !ok || failure.Reason != "Unregistered torrent" {
+		t.Errorf("Expected failure, got %v", err)
+	}
+	req.InfoHash = "short"
+	if _, err := tracker.Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected an error for an invalid info hash")
+	}
+}
+
+func TestTrackerServerUDP(t *testing.T) {
+	store := NewSwarmStore()
+	server := NewTrackerServer(store)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	defer conn.Close()
+	go server.ServeUDP(conn)
+
+	infoHash := InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	store.Announce(TrackerRequest{InfoHash: infoHash, PeerId: "bbbbbbbbbbbbbbbbbbbb", Port: "80", Left: 100},
+		net.ParseIP("2001:db8::1"))
+	store.Announce(TrackerRequest{InfoHash: infoHash, PeerId: "cccccccccccccccccccc", Port: "81", Left: 100},
+		net.IPv4(10, 0, 0, 1))
+
+	tracker := NewTracker("udp://" + conn.LocalAddr().String())
+	tracker.UDPTimeout = time.Second
+	req := TestTrackerRequest
+	req.InfoHash = infoHash
+	req.Left = 100
+	resp, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	// Only peers of the same address family are sent over UDP.
+	if len(resp.Peers) != 1 || resp.Peers[0].String() != "10.0.0.1:81" || len(resp.Peers6) != 0 {
+		t.Errorf("Unexpected peers %v %v", resp.Peers, resp.Peers6)
+	}
+	if resp.Interval != 1800 || resp.Complete != 0 || resp.Incomplete != 3 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	stats, err := tracker.Scrape(context.Background(), infoHash, InfoHash("unknownunknownunknow"))
+	if err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	if stats[infoHash] != (ScrapeStats{Incomplete: 3}) ||
+		stats[InfoHash("unknownunknownunknow")] != (ScrapeStats{}) {
+		t.Errorf("Unexpected scrape %v", stats)
+	}
+
+	// Requests with a made up connection ID are refused.
+	tracker.mu.Lock()
+	tracker.connectionId++
+	tracker.mu.Unlock()
+	_, err = tracker.Announce(context.Background(), req)
+	if failure, ok := err.(*TrackerFailure); !ok || failure.Reason != "Invalid connection id" {
+		t.Errorf("Expected failure, got %v", err)
+	}
+}
+
+func TestTrackerServerExpiresIdleSwarms(t *testing.T) {
+	store := NewSwarmStore()
+	var mu sync.Mutex
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	server := NewTrackerServer(store)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	tracker := NewTracker(httpServer.URL + "/announce")
+
+	idle := InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	active := InfoHash("bbbbbbbbbbbbbbbbbbbb")
+	req := TestTrackerRequest
+	req.InfoHash = idle
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+
+	// The idle swarm gets no more announces, but is dropped once its peer times out.
+	mu.Lock()
+	now = now.Add(store.PeerTimeout + time.Minute)
+	mu.Unlock()
+	req.InfoHash = active
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.swarms[idle]; ok || len(store.swarms) != 1 {
+		t.Errorf("Expected only the active swarm, got %v swarms", len(store.swarms))
+	}
+}
@@ -0,0 +1,221 @@
+package gotorrent
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTrackerAnnounce(t *testing.T) {
+	infoHash := InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	responses := map[string]string{
+		"compact": "d8:completei5e10:incompletei3e8:intervali1800e12:min intervali60e" +
+			"5:peers12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50" +
+			"10:tracker id3:abc15:warning message4:slowe",
+		"dict": "d8:intervali900e5:peersl" +
+			"d2:ip8:10.0.0.17:peer id20:bbbbbbbbbbbbbbbbbbbb4:porti6881ee" +
+			"d2:ip3:::14:porti6882ee" +
+			"d2:ip11:example.com4:porti6883ee" +
+			"ee",
+		"failure":   "d14:failure reason15:unknown torrente",
+		"truncated": "d8:intervali5e5:peers100:abce",
+	}
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		response, ok := responses[r.URL.Query().Get("kind")]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	req := TestTrackerRequest
+	req.InfoHash = infoHash
+	req.Compact = true
+	resp, err := NewTracker(server.URL+"/announce?kind=compact").Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if query["info_hash"][0] != string(infoHash) || query["compact"][0] != "1" ||
+		query["peer_id"][0] != TestTrackerRequest.PeerId || query["kind"][0] != "compact" {
+		t.Errorf("Unexpected query %v", query)
+	}
+	expectedPeers := PeerList{
+		{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881},
+		{IP: net.IPv4(192, 168, 1, 2).To4(), Port: 80},
+	}
+	if !reflect.DeepEqual(expectedPeers, resp.Peers) {
+		t.Errorf("Expected %v, Actual %v", expectedPeers, resp.Peers)
+	}
+	if resp.Interval != 1800 || resp.MinInterval != 60 || resp.Complete != 5 || resp.Incomplete != 3 ||
+		resp.TrackerId != "abc" || resp.WarningMessage != "slow" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	resp, err = NewTracker(server.URL+"/announce?kind=dict").Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	expectedPeers = PeerList{
+		{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881, PeerId: "bbbbbbbbbbbbbbbbbbbb"},
+		{IP: net.ParseIP("::1"), Port: 6882},
+	}
+	if !reflect.DeepEqual(expectedPeers, resp.Peers) {
+		t.Errorf("Expected %v, Actual %v", expectedPeers, resp.Peers)
+	}
+	if expected := "[::1]:6882"; resp.Peers[1].String() != expected {
+		t.Errorf("Expected %v, Actual %v", expected, resp.Peers[1])
+	}
+
+	_, err = NewTracker(server.URL+"/announce?kind=failure").Announce(context.Background(), req)
+	if failure, ok := err.(*TrackerFailure); !ok || failure.Reason != "unknown torrent" {
+		t.Errorf("Expected tracker failure, Actual %v", err)
+	}
+
+	if _, err := NewTracker(server.URL+"/announce?kind=truncated").Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected error for a truncated response")
+	}
+	if _, err := NewTracker(server.URL+"/missing").Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected error for 404")
+	}
+	if _, err := NewTracker("gopher://x/").Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected error for unsupported protocol")
+	}
+}
+
+func TestTrackerAnnounceKeepsPasskey(t *testing.T) {
+	var rawQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQuery = r.URL.RawQuery
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer server.Close()
+
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaa ")
+	if _, err := NewTracker(server.URL+"/announce?passkey=a%2Fb+c").Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	expected := "passkey=a%2Fb+c&" + req.Query()
+	if rawQuery != expected {
+		t.Errorf("Expected %v, Actual %v", expected, rawQuery)
+	}
+	if !strings.Contains(rawQuery, "info_hash=aaaaaaaaaaaaaaaaaaa%20&") {
+		t.Errorf("Info hash wasn't percent-encoded exactly: %v", rawQuery)
+	}
+}
+
+func TestDecodeCompactPeersRejectsBadLength(t *testing.T) {
+	if _, err := decodeCompactPeers("12345", net.IPv4len); err == nil {
+		t.Errorf("Expected error for truncated peer list")
+	}
+}
+
+func TestTrackerAnnouncePeers6(t *testing.T) {
+	response := "d8:intervali900e5:peers6:\x0a\x00\x00\x01\x1a\xe16:peers618:" +
+		"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe2e"
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	req := TestTrackerRequest
+	req.Ipv4 = "10.0.0.1"
+	req.Ipv6 = "2001:db8::1"
+	resp, err := NewTracker(server.URL).Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if query["ipv4"][0] != "10.0.0.1" || query["ipv6"][0] != "2001:db8::1" {
+		t.Errorf("Unexpected query %v", query)
+	}
+	if _, ok := query["ip"]; ok {
+		t.Errorf("Expected empty ip to be omitted, Actual %v", query["ip"])
+	}
+	expected := []PeerAddr{
+		{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881},
+		{IP: net.ParseIP("2001:db8::1"), Port: 6882},
+	}
+	if actual := resp.AllPeers(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+}
+
+func TestTrackerAnnounceDualStack(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali900e5:peers6:\x0a\x00\x00\x01\x1a\xe1e"))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// The tracker is only reachable over IPv4, so only that announce succeeds.
+	resp, err := NewTracker(server.URL).AnnounceDualStack(context.Background(), TestTrackerRequest)
+	if err != nil || len(resp.Peers) != 1 {
+		t.Errorf("Unexpected response %v, err %v", resp, err)
+	}
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	server6 := httptest.NewUnstartedServer(handler)
+	server6.Listener = listener
+	server6.Start()
+	defer server6.Close()
+	resp, err = NewTracker(server6.URL).AnnounceDualStack(context.Background(), TestTrackerRequest)
+	if err != nil || len(resp.Peers) != 1 {
+		t.Errorf("Unexpected response %v, err %v", resp, err)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper which isn't an *http.Transport.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTrackerAnnounceDualStackCustomRoundTripper(t *testing.T) {
+	var requests int32
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader("d8:intervali900e5:peers6:\x0a\x00\x00\x01\x1a\xe1e")),
+			Request:    r,
+		}, nil
+	})}
+	// The caller's RoundTripper is used, once, rather than being replaced.
+	tracker := &Tracker{Url: "http://tracker.invalid/announce", Client: client}
+	resp, err := tracker.AnnounceDualStack(context.Background(), TestTrackerRequest)
+	if err != nil || len(resp.Peers) != 1 {
+		t.Errorf("Unexpected response %v, err %v", resp, err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %v", requests)
+	}
+}
+
+func TestMergeTrackerResponses(t *testing.T) {
+	peer4 := PeerAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1}
+	peer6 := PeerAddr{IP: net.ParseIP("2001:db8::1"), Port: 2}
+	a := &TrackerResponse{Interval: 900, Complete: 3, Peers: PeerList{peer4}}
+	b := &TrackerResponse{Interval: 1800, Complete: 5, Incomplete: 1, WarningMessage: "w",
+		Peers: PeerList{peer4}, Peers6: Peer6List{peer6}}
+	expected := &TrackerResponse{Interval: 900, Complete: 5, Incomplete: 1, WarningMessage: "w",
+		Peers: PeerList{peer4}, Peers6: Peer6List{peer6}}
+	if actual := mergeTrackerResponses(a, b); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %+v, Actual %+v", expected, actual)
+	}
+}
@@ -0,0 +1,162 @@
+package gotorrent
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// TrackerTiers holds the trackers for a single torrent, grouped into tiers as described in
+// https://wiki.theory.org/BitTorrentSpecification#Metainfo_File_Structure and BEP 12.
+// Each tier is shuffled once when the TrackerTiers is created.  Trackers are tried in
+// order, a tracker that responds is moved to the front of its tier, and later tiers are
+// only used when every tracker in the earlier tiers has failed.
+// A TrackerTiers is safe for concurrent use.
+type TrackerTiers struct {
+	mu     sync.Mutex
+	tiers  [][]string
+	active string
+	errors map[string]error
+	// added is set once the last tier holds trackers added with Add.
+	added bool
+}
+
+// NewTrackerTiers returns the tracker tiers for metaInfo.  If the torrent has an
+// announce-list, the announce key is ignored, as required by BEP 12.
+func NewTrackerTiers(metaInfo MetaInfo) *TrackerTiers {
+	return newTrackerTiers(metaInfo, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+func newTrackerTiers(metaInfo MetaInfo, r *rand.Rand) *TrackerTiers {
+	t := &TrackerTiers{errors: map[string]error{}}
+	for _, tier := range metaInfo.Announce_List {
+		var shuffled []string
+		for _, i := range r.Perm(len(tier)) {
+			if tier[i] != "" {
+				shuffled = append(shuffled, tier[i])
+			}
+		}
+		if len(shuffled) > 0 {
+			t.tiers = append(t.tiers, shuffled)
+		}
+	}
+	if len(t.tiers) == 0 && metaInfo.Announce != "" {
+		t.tiers = [][]string{{metaInfo.Announce}}
+	}
+	return t
+}
+
+// Announce calls announce with each tracker in turn until one of them succeeds.  The
+// successful tracker becomes the active tracker and is promoted to the front of its tier.
+// If every tracker fails, the error from the last one is returned.
+func (t *TrackerTiers) Announce(announce func(trackerUrl string) error) error {
+	lastErr := fmt.Errorf("No trackers available")
+	for tierIndex := 0; ; tierIndex++ {
+		// Each tier is copied, since promote and Add change the tiers while the lock isn't
+		// held, and would otherwise make trackers be skipped or tried twice.
+		t.mu.Lock()
+		if tierIndex >= len(t.tiers) {
+			t.mu.Unlock()
+			return lastErr
+		}
+		tier := append([]string(nil), t.tiers[tierIndex]...)
+		t.mu.Unlock()
+
+		for _, trackerUrl := range tier {
+			// The lock is not held while announcing, since announces can take a long time.
+			err := announce(trackerUrl)
+
+			t.mu.Lock()
+			t.errors[trackerUrl] = err
+			if err == nil {
+				t.promote(trackerUrl)
+				t.active = trackerUrl
+				t.mu.Unlock()
+				return nil
+			}
+			if t.active == trackerUrl {
+				t.active = ""
+			}
+			t.mu.Unlock()
+			lastErr = err
+		}
+	}
+}
+
+// promote moves trackerUrl to the front of its tier.  t.mu must be held.
+func (t *TrackerTiers) promote(trackerUrl string) {
+	for _, tier := range t.tiers {
+		for i, u := range tier {
+			if u == trackerUrl {
+				copy(tier[1:i+1], tier[:i])
+				tier[0] = trackerUrl
+				return
+			}
+		}
+	}
+}
+
+// Active returns the tracker that responded to the most recent announce, or "" if there
+// has not been a successful announce yet.
+func (t *TrackerTiers) Active() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// Tiers returns a copy of the tiers in the order they will next be tried.
+func (t *TrackerTiers) Tiers() [][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tiers := make([][]string, len(t.tiers))
+	for i, tier := range t.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// LastError returns the error from the most recent announce to trackerUrl, or nil if the
+// announce succeeded or the tracker has not been tried.
+func (t *TrackerTiers) LastError(trackerUrl string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.errors[trackerUrl]
+}
+
+// Working returns the trackers whose most recent announce succeeded, in tier order.
+func (t *TrackerTiers) Working() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var working []string
+	for _, tier := range t.tiers {
+		for _, u := range tier {
+			if err, ok := t.errors[u]; ok && err == nil {
+				working = append(working, u)
+			}
+		}
+	}
+	return working
+}
+
+// Add adds trackerUrl to a tier after every tier from the metainfo, so that it is only
+// tried once the original trackers have failed.  It returns false if the tracker is
+// already known.
+func (t *TrackerTiers) Add(trackerUrl string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tier := range t.tiers {
+		for _, u := range tier {
+			if u == trackerUrl {
+				return false
+			}
+		}
+	}
+	if !t.added {
+		t.tiers = append(t.tiers, nil)
+		t.added = true
+	}
+	last := len(t.tiers) - 1
+	t.tiers[last] = append(t.tiers[last], trackerUrl)
+	return true
+}
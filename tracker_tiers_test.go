@@ -0,0 +1,124 @@
+package gotorrent
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestTrackerTiersFallsBackToAnnounce(t *testing.T) {
+	var metaInfo MetaInfo
+	metaInfo.Announce = "http://a/announce"
+	tiers := newTrackerTiers(metaInfo, rand.New(rand.NewSource(1)))
+	expected := [][]string{{"http://a/announce"}}
+	if actual := tiers.Tiers(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+}
+
+func TestTrackerTiersIgnoresAnnounceWithAnnounceList(t *testing.T) {
+	var metaInfo MetaInfo
+	metaInfo.Announce = "http://a/announce"
+	metaInfo.Announce_List = [][]string{{}, {"http://b/announce"}}
+	tiers := newTrackerTiers(metaInfo, rand.New(rand.NewSource(1)))
+	expected := [][]string{{"http://b/announce"}}
+	if actual := tiers.Tiers(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+}
+
+func TestTrackerTiersAnnounce(t *testing.T) {
+	var metaInfo MetaInfo
+	metaInfo.Announce_List = [][]string{{"a1", "a2", "a3"}, {"b1", "b2"}}
+	tiers := newTrackerTiers(metaInfo, rand.New(rand.NewSource(1)))
+	initial := tiers.Tiers()
+
+	// Only the last tracker of the first tier works.
+	working := initial[0][2]
+	var tried []string
+	err := tiers.Announce(func(trackerUrl string) error {
+		tried = append(tried, trackerUrl)
+		if trackerUrl != working {
+			return fmt.Errorf("%v is down", trackerUrl)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(initial[0], tried) {
+		t.Errorf("Expected to try %v, Actual %v", initial[0], tried)
+	}
+	if tiers.Active() != working {
+		t.Errorf("Expected active %v, Actual %v", working, tiers.Active())
+	}
+	expected := []string{working, initial[0][0], initial[0][1]}
+	if actual := tiers.Tiers()[0]; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, Actual %v", expected, actual)
+	}
+	if tiers.LastError(initial[0][0]) == nil {
+		t.Errorf("Expected error to be recorded for %v", initial[0][0])
+	}
+
+	// Now the whole first tier is down, so the second tier is used.
+	tried = nil
+	err = tiers.Announce(func(trackerUrl string) error {
+		tried = append(tried, trackerUrl)
+		if trackerUrl != initial[1][1] {
+			return fmt.Errorf("%v is down", trackerUrl)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(tried) != 5 {
+		t.Errorf("Expected 5 announces, Actual %v", tried)
+	}
+	if tiers.Active() != initial[1][1] {
+		t.Errorf("Expected active %v, Actual %v", initial[1][1], tiers.Active())
+	}
+	if actual := tiers.Tiers()[1][0]; actual != initial[1][1] {
+		t.Errorf("Expected %v to be promoted, Actual %v", initial[1][1], actual)
+	}
+
+	// Nothing works.
+	err = tiers.Announce(func(trackerUrl string) error {
+		return fmt.Errorf("%v is down", trackerUrl)
+	})
+	if err == nil {
+		t.Errorf("Expected error when all trackers are down")
+	}
+	if tiers.Active() != "" {
+		t.Errorf("Expected no active tracker, Actual %v", tiers.Active())
+	}
+}
+
+func TestTrackerTiersAnnounceWhileChanging(t *testing.T) {
+	var metaInfo MetaInfo
+	metaInfo.Announce_List = [][]string{{"a1", "a2", "a3"}}
+	tiers := newTrackerTiers(metaInfo, rand.New(rand.NewSource(1)))
+	initial := tiers.Tiers()[0]
+
+	// Another announce promotes the last tracker and a tracker is added while the first
+	// is being tried, which must not make trackers be skipped or tried twice.
+	var tried []string
+	tiers.Announce(func(trackerUrl string) error {
+		tried = append(tried, trackerUrl)
+		if len(tried) == 1 {
+			tiers.Announce(func(u string) error {
+				if u == initial[2] {
+					return nil
+				}
+				return fmt.Errorf("%v is down", u)
+			})
+			tiers.Add("x1")
+		}
+		return fmt.Errorf("%v is down", trackerUrl)
+	})
+	expected := append(append([]string(nil), initial...), "x1")
+	if !reflect.DeepEqual(expected, tried) {
+		t.Errorf("Expected %v, Actual %v", expected, tried)
+	}
+}
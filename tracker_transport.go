@@ -0,0 +1,141 @@
+package gotorrent
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultMaxTrackerResponseSize limits how much of a tracker response is read, unless a
+// TrackerTransport says otherwise.
+const defaultMaxTrackerResponseSize = 1 << 20
+
+// TrackerTransport configures how HTTP trackers are contacted, for announces and scrapes.
+// A single TrackerTransport may be shared by many Trackers, but must not be changed once
+// it is in use.
+type TrackerTransport struct {
+	// Client is used as is if set, and TLSConfig, Proxy, Timeout and MaxRedirects are
+	// ignored.  Otherwise a client is built from them.
+	Client *http.Client
+	// TLSConfig holds client certificates and trusted roots for HTTPS trackers.
+	TLSConfig *tls.Config
+	// Proxy returns the proxy to use for a request.  If nil, the proxy is taken from the
+	// environment, as with http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Timeout limits the time taken by a single request, including redirects.
+	Timeout time.Duration
+	// MaxRedirects is the number of redirects followed.  Zero uses Go's default of 10, and
+	// a negative number refuses every redirect.  Redirects from HTTPS to HTTP are always
+	// refused, so that passkeys aren't sent in the clear.
+	MaxRedirects int
+
+	// UserAgent is sent in the User-Agent header if set.
+	UserAgent string
+	// Headers are added to requests to the tracker host named by the key, for instance
+	// to authenticate with a private tracker.
+	Headers map[string]http.Header
+	// MaxResponseSize limits the size of tracker responses.  Zero means 1MiB.
+	MaxResponseSize int64
+
+	once   sync.Once
+	client *http.Client
+}
+
+// httpClient returns the client used for requests.
+func (tt *TrackerTransport) httpClient() *http.Client {
+	tt.once.Do(func() {
+		if tt.Client != nil {
+			tt.client = tt.Client
+			return
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if tt.TLSConfig != nil {
+			transport.TLSClientConfig = tt.TLSConfig
+		}
+		if tt.Proxy != nil {
+			transport.Proxy = tt.Proxy
+		}
+		tt.client = &http.Client{
+			Transport:     transport,
+			Timeout:       tt.Timeout,
+			CheckRedirect: tt.checkRedirect,
+		}
+	})
+	return tt.client
+}
+
+func (tt *TrackerTransport) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := tt.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+	if len(via) >= maxRedirects || maxRedirects < 0 {
+		return fmt.Errorf("Stopped after %v redirects", len(via))
+	}
+	previous := via[len(via)-1]
+	if previous.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return errors.New("Refusing to redirect from HTTPS to HTTP")
+	}
+	// The headers of the first request are copied to redirects, but headers meant for one
+	// host shouldn't go to another.
+	if host := req.URL.Hostname(); host != via[0].URL.Hostname() {
+		for key := range tt.Headers[via[0].URL.Hostname()] {
+			req.Header.Del(key)
+		}
+		tt.addHeaders(req)
+	}
+	return nil
+}
+
+// addHeaders adds the user agent and the headers for req's host to req.
+func (tt *TrackerTransport) addHeaders(req *http.Request) {
+	if tt.UserAgent != "" {
+		req.Header.Set("User-Agent", tt.UserAgent)
+	}
+	for key, values := range tt.Headers[req.URL.Hostname()] {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
+
+func (tt *TrackerTransport) maxResponseSize() int64 {
+	if tt == nil || tt.MaxResponseSize <= 0 {
+		return defaultMaxTrackerResponseSize
+	}
+	return tt.MaxResponseSize
+}
+
+// get fetches trackerUrl, using t's transport settings, and returns the response with
+// its body.
+func (t *Tracker) get(ctx context.Context, trackerUrl string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", trackerUrl, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Couldn't create request for %v: %v", t.Url, err)
+	}
+	if t.Transport != nil {
+		t.Transport.addHeaders(req)
+	}
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	maxSize := t.Transport.maxResponseSize()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, nil, fmt.Errorf("Response from %v is larger than %v bytes", t.Url, maxSize)
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,169 @@
+package gotorrent
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const transportTestResponse = "d8:intervali1800e5:peers0:e"
+
+func TestTrackerTransportHeaders(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+		if strings.HasSuffix(r.URL.Path, "scrape") {
+			w.Write([]byte("d5:filesdee"))
+			return
+		}
+		w.Write([]byte(transportTestResponse))
+	}))
+	defer server.Close()
+
+	tracker := NewTracker(server.URL + "/announce")
+	tracker.Transport = &TrackerTransport{
+		UserAgent: "gotorrent/0.1",
+		Headers: map[string]http.Header{
+			"127.0.0.1": {"X-Api-Key": {"secret"}},
+			"example":   {"X-Other": {"x"}},
+		},
+	}
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if _, err := tracker.Scrape(context.Background(), req.InfoHash); err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	for _, h := range headers {
+		if h.Get("User-Agent") != "gotorrent/0.1" || h.Get("X-Api-Key") != "secret" || h.Get("X-Other") != "" {
+			t.Errorf("Unexpected headers %v", h)
+		}
+	}
+}
+
+func TestTrackerTransportResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(transportTestResponse))
+	}))
+	defer server.Close()
+
+	tracker := NewTracker(server.URL + "/announce")
+	tracker.Transport = &TrackerTransport{MaxResponseSize: int64(len(transportTestResponse))}
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Errorf("Error announcing: %v", err)
+	}
+	tracker.Transport = &TrackerTransport{MaxResponseSize: int64(len(transportTestResponse) - 1)}
+	if _, err := tracker.Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected an error for a large response")
+	}
+}
+
+func TestTrackerTransportRedirects(t *testing.T) {
+	var redirectedHeaders http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirectedHeaders = r.Header
+		w.Write([]byte(transportTestResponse))
+	}))
+	defer target.Close()
+	// The target is reached by another name, so the per-host headers differ.
+	targetUrl := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	redirect := httptest.NewServer(http.RedirectHandler(targetUrl+"/announce", http.StatusFound))
+	defer redirect.Close()
+
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	transport := &TrackerTransport{
+		Headers: map[string]http.Header{
+			"127.0.0.1": {"X-Api-Key": {"secret"}},
+			"localhost": {"X-Other": {"x"}},
+		},
+	}
+	tracker := NewTracker(redirect.URL + "/announce")
+	tracker.Transport = transport
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if redirectedHeaders.Get("X-Api-Key") != "" || redirectedHeaders.Get("X-Other") != "x" {
+		t.Errorf("Unexpected headers after redirect %v", redirectedHeaders)
+	}
+
+	tracker.Transport = &TrackerTransport{MaxRedirects: -1}
+	if _, err := tracker.Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected an error when redirects are refused")
+	}
+
+	secure := httptest.NewTLSServer(http.RedirectHandler(target.URL+"/announce", http.StatusFound))
+	defer secure.Close()
+	tracker = NewTracker(secure.URL + "/announce")
+	tracker.Transport = &TrackerTransport{
+		TLSConfig: secure.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+	_, err := tracker.Announce(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "HTTPS to HTTP") {
+		t.Errorf("Expected an error redirecting from HTTPS to HTTP, got %v", err)
+	}
+}
+
+func TestTrackerTransportTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(transportTestResponse))
+	}))
+	defer server.Close()
+
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	tracker := NewTracker(server.URL + "/announce")
+	tracker.Transport = &TrackerTransport{TLSConfig: &tls.Config{}}
+	if _, err := tracker.Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected an error for an untrusted certificate")
+	}
+	tracker.Transport = &TrackerTransport{
+		TLSConfig: server.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Errorf("Error announcing: %v", err)
+	}
+}
+
+func TestTrackerTransportProxyAndTimeout(t *testing.T) {
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		if r.URL.Path == "/slow/announce" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(transportTestResponse))
+	}))
+	defer proxy.Close()
+	proxyUrl, _ := url.Parse(proxy.URL)
+
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	transport := &TrackerTransport{
+		Proxy:   http.ProxyURL(proxyUrl),
+		Timeout: 50 * time.Millisecond,
+	}
+	tracker := NewTracker("http://tracker.invalid/announce")
+	tracker.Transport = transport
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing through proxy: %v", err)
+	}
+	if proxiedHost != "tracker.invalid" {
+		t.Errorf("Expected the request to go through the proxy, got host %v", proxiedHost)
+	}
+
+	tracker = NewTracker("http://tracker.invalid/slow/announce")
+	tracker.Transport = transport
+	if _, err := tracker.Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected a timeout")
+	}
+}
@@ -0,0 +1,268 @@
+package gotorrent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// UDP tracker protocol, see BEP 15.
+const (
+	udpProtocolId = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	// Clients may use a connection ID for a minute after receiving it.
+	udpConnectionIdLifetime = time.Minute
+	// Responses can't be larger than this, since scrapes are limited to 74 info hashes.
+	maxUDPPacketSize = 2048
+	// maxUDPScrapeSize is the most info hashes which fit in a scrape request.
+	maxUDPScrapeSize = 74
+)
+
+var udpEvents = map[string]uint32{
+	"":          0,
+	"completed": 1,
+	"started":   2,
+	"stopped":   3,
+}
+
+// errUDPTimeout is returned when no response arrives within the retransmission timeout.
+var errUDPTimeout = errors.New("UDP tracker timed out")
+
+func (t *Tracker) udpTimeout(attempt int) time.Duration {
+	timeout := t.UDPTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	return timeout << uint(attempt)
+}
+
+func (t *Tracker) udpRetries() int {
+	if t.UDPRetries > 0 {
+		return t.UDPRetries
+	}
+	return 8
+}
+
+func (t *Tracker) announceUDP(ctx context.Context, host string, req TrackerRequest) (*TrackerResponse, error) {
+	event, ok := udpEvents[req.Event]
+	if !ok {
+		return nil, fmt.Errorf("Unknown event %v", req.Event)
+	}
+	port, err := strconv.Atoi(req.Port)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port %v", req.Port)
+	}
+	if len(req.InfoHash) != 20 || len(req.PeerId) != 20 {
+		return nil, fmt.Errorf("Info hash and peer id must be 20 bytes")
+	}
+	body := make([]byte, 82)
+	copy(body[0:20], req.InfoHash)
+	copy(body[20:40], req.PeerId)
+	binary.BigEndian.PutUint64(body[40:48], uint64(req.Downloaded))
+	binary.BigEndian.PutUint64(body[48:56], uint64(req.Left))
+	binary.BigEndian.PutUint64(body[56:64], uint64(req.Uploaded))
+	binary.BigEndian.PutUint32(body[64:68], event)
+	if ip := net.ParseIP(req.Ip).To4(); ip != nil {
+		copy(body[68:72], ip)
+	}
+	// The key is a 32 bit number, which we send to HTTP trackers in hex.
+	if key, err := strconv.ParseUint(req.Key, 16, 32); err == nil {
+		binary.BigEndian.PutUint32(body[72:76], uint32(key))
+	}
+	numWant := int32(-1)
+	if req.Numwant > 0 {
+		numWant = int32(req.Numwant)
+	}
+	binary.BigEndian.PutUint32(body[76:80], uint32(numWant))
+	binary.BigEndian.PutUint16(body[80:82], uint16(port))
+
+	response, remote, err := t.udpRequest(ctx, host, udpActionAnnounce, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 12 {
+		return nil, fmt.Errorf("Short announce response from %v", t.Url)
+	}
+	trackerResponse := &TrackerResponse{
+		Interval:   int(binary.BigEndian.Uint32(response[0:4])),
+		Incomplete: int(binary.BigEndian.Uint32(response[4:8])),
+		Complete:   int(binary.BigEndian.Uint32(response[8:12])),
+	}
+	// Trackers reply with peers of the same address family as the request.
+	if remote.IP.To4() != nil {
+		trackerResponse.Peers, err = decodeCompactPeers(string(response[12:]), net.IPv4len)
+	} else {
+		var peers PeerList
+		peers, err = decodeCompactPeers(string(response[12:]), net.IPv6len)
+		trackerResponse.Peers6 = Peer6List(peers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid announce response from %v: %v", t.Url, err)
+	}
+	return trackerResponse, nil
+}
+
+func (t *Tracker) scrapeUDP(ctx context.Context, host string, infoHashes []InfoHash) (map[InfoHash]ScrapeStats, error) {
+	body := make([]byte, 0, 20*len(infoHashes))
+	for _, h := range infoHashes {
+		if !h.IsV1() {
+			return nil, fmt.Errorf("UDP trackers only support v1 info hashes, not %v", h)
+		}
+		body = append(body, h...)
+	}
+	response, _, err := t.udpRequest(ctx, host, udpActionScrape, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) != 12*len(infoHashes) {
+		return nil, fmt.Errorf("Scrape response from %v has %v bytes for %v info hashes",
+			t.Url, len(response), len(infoHashes))
+	}
+	stats := map[InfoHash]ScrapeStats{}
+	for i, h := range infoHashes {
+		entry := response[12*i:]
+		stats[h] = ScrapeStats{
+			Complete:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Downloaded: int(binary.BigEndian.Uint32(entry[4:8])),
+			Incomplete: int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+	return stats, nil
+}
+
+// udpRequest sends a request with the given action and body to the tracker at host,
+// connecting first if there is no valid connection ID, and retransmitting on timeouts.
+// It returns the body of the response, after the action and transaction ID.
+func (t *Tracker) udpRequest(ctx context.Context, host string, action uint32, body []byte) ([]byte, *net.UDPAddr, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp"+t.ipVersion, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	remote, _ := conn.RemoteAddr().(*net.UDPAddr)
+
+	for attempt := 0; attempt <= t.udpRetries(); attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		connectionId, ok := t.cachedConnectionId()
+		if !ok {
+			connect := make([]byte, 8)
+			binary.BigEndian.PutUint64(connect, udpProtocolId)
+			response, err := t.udpExchange(ctx, conn, udpActionConnect, connect, attempt)
+			if err == errUDPTimeout && ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if err == errUDPTimeout {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(response) < 8 {
+				return nil, nil, fmt.Errorf("Short connect response from %v", t.Url)
+			}
+			connectionId = binary.BigEndian.Uint64(response)
+			t.mu.Lock()
+			t.connectionId, t.connectionIdTime = connectionId, time.Now()
+			t.mu.Unlock()
+		}
+
+		packet := make([]byte, 8, 8+len(body))
+		binary.BigEndian.PutUint64(packet, connectionId)
+		packet = append(packet, body...)
+		response, err := t.udpExchange(ctx, conn, action, packet, attempt)
+		if err == errUDPTimeout && ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err == errUDPTimeout {
+			continue
+		}
+		return response, remote, err
+	}
+	return nil, nil, fmt.Errorf("No response from UDP tracker %v", t.Url)
+}
+
+// randomUint32 returns a number from crypto/rand, for the values which stop responses
+// and announces from being spoofed.
+func randomUint32() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return binary.BigEndian.Uint32(b)
+}
+
+func (t *Tracker) cachedConnectionId() (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.connectionIdTime.IsZero() || time.Since(t.connectionIdTime) > udpConnectionIdLifetime {
+		return 0, false
+	}
+	return t.connectionId, true
+}
+
+// udpExchange sends a single packet, which is prefix followed by the action and a new
+// transaction ID, and waits for the matching response.
+func (t *Tracker) udpExchange(ctx context.Context, conn net.Conn, action uint32, packet []byte, attempt int) ([]byte, error) {
+	transactionId := randomUint32()
+	// The connection ID or protocol ID comes first, then the action and transaction ID.
+	request := make([]byte, 0, len(packet)+8)
+	request = append(request, packet[:8]...)
+	request = binary.BigEndian.AppendUint32(request, action)
+	request = binary.BigEndian.AppendUint32(request, transactionId)
+	request = append(request, packet[8:]...)
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(t.udpTimeout(attempt))
+	ctxDeadline, ok := ctx.Deadline()
+	ctxDeadlineFirst := ok && ctxDeadline.Before(deadline)
+	if ctxDeadlineFirst {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+	// Unblock the read if the context is cancelled.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buffer := make([]byte, maxUDPPacketSize)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// The context's deadline may pass before ctx.Err is set.
+				if ctxDeadlineFirst {
+					return nil, context.DeadlineExceeded
+				}
+				return nil, errUDPTimeout
+			}
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buffer[4:8]) != transactionId {
+			// A late response to an earlier attempt, or garbage.
+			continue
+		}
+		responseAction := binary.BigEndian.Uint32(buffer[0:4])
+		if responseAction == udpActionError {
+			return nil, &TrackerFailure{t.Url, string(buffer[8:n])}
+		}
+		if responseAction != action {
+			return nil, fmt.Errorf("Unexpected action %v from %v, expected %v", responseAction, t.Url, action)
+		}
+		return append([]byte(nil), buffer[8:n]...), nil
+	}
+}
@@ -0,0 +1,227 @@
+package gotorrent
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// udpTestTracker is a stand-in UDP tracker.  It drops the first packet it receives, to
+// exercise retransmission, and answers every announce with the same two peers.
+type udpTestTracker struct {
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	packets   int
+	connects  int
+	announces [][]byte
+}
+
+const udpTestConnectionId = 0x1122334455667788
+
+func newUDPTestTracker(t *testing.T) *udpTestTracker {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	tracker := &udpTestTracker{conn: conn}
+	go tracker.serve()
+	return tracker
+}
+
+func (s *udpTestTracker) Url() string {
+	return "udp://" + s.conn.LocalAddr().String() + "/announce"
+}
+
+func (s *udpTestTracker) serve() {
+	buffer := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		packet := buffer[:n]
+		s.mu.Lock()
+		s.packets++
+		drop := s.packets == 1
+		s.mu.Unlock()
+		if drop || n < 16 {
+			continue
+		}
+		action := binary.BigEndian.Uint32(packet[8:12])
+		response := append([]byte{}, packet[8:16]...)
+		switch {
+		case action == udpActionConnect && binary.BigEndian.Uint64(packet) == udpProtocolId:
+			s.mu.Lock()
+			s.connects++
+			s.mu.Unlock()
+			response = binary.BigEndian.AppendUint64(response, udpTestConnectionId)
+		case binary.BigEndian.Uint64(packet) != udpTestConnectionId:
+			response = udpErrorResponse(response, "bad connection id")
+		case action == udpActionAnnounce && string(packet[16:36]) == "unknownunknownunknow":
+			response = udpErrorResponse(response, "unknown torrent")
+		case action == udpActionAnnounce:
+			s.mu.Lock()
+			s.announces = append(s.announces, append([]byte{}, packet...))
+			s.mu.Unlock()
+			response = binary.BigEndian.AppendUint32(response, 1800)
+			response = binary.BigEndian.AppendUint32(response, 3)
+			response = binary.BigEndian.AppendUint32(response, 5)
+			response = append(response, 10, 0, 0, 1, 0x1a, 0xe1, 192, 168, 1, 2, 0, 80)
+		case action == udpActionScrape:
+			for i := 16; i+20 <= n; i += 20 {
+				response = binary.BigEndian.AppendUint32(response, uint32(packet[i]))
+				response = binary.BigEndian.AppendUint32(response, 7)
+				response = binary.BigEndian.AppendUint32(response, 2)
+			}
+		}
+		s.conn.WriteToUDP(response, addr)
+	}
+}
+
+func TestUDPTrackerAnnounce(t *testing.T) {
+	server := newUDPTestTracker(t)
+	defer server.conn.Close()
+
+	tracker := NewTracker(server.Url())
+	tracker.UDPTimeout = 50 * time.Millisecond
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	req.Left = 1000
+	req.Uploaded = 20
+	req.Downloaded = 30
+	req.Key = "1A2B3C4D"
+	req.Numwant = 10
+	resp, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	expectedPeers := PeerList{
+		{IP: net.IP{10, 0, 0, 1}, Port: 6881},
+		{IP: net.IP{192, 168, 1, 2}, Port: 80},
+	}
+	if !reflect.DeepEqual(expectedPeers, resp.Peers) {
+		t.Errorf("Expected %v, Actual %v", expectedPeers, resp.Peers)
+	}
+	if resp.Interval != 1800 || resp.Incomplete != 3 || resp.Complete != 5 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	server.mu.Lock()
+	announce := server.announces[0]
+	server.mu.Unlock()
+	if len(announce) != 98 {
+		t.Fatalf("Expected a 98 byte announce, got %v", len(announce))
+	}
+	if string(announce[16:36]) != string(req.InfoHash) || string(announce[36:56]) != req.PeerId {
+		t.Errorf("Unexpected info hash or peer id in %x", announce)
+	}
+	if binary.BigEndian.Uint64(announce[56:64]) != 30 || binary.BigEndian.Uint64(announce[64:72]) != 1000 ||
+		binary.BigEndian.Uint64(announce[72:80]) != 20 || binary.BigEndian.Uint32(announce[80:84]) != 2 ||
+		binary.BigEndian.Uint32(announce[88:92]) != 0x1a2b3c4d || binary.BigEndian.Uint32(announce[92:96]) != 10 ||
+		binary.BigEndian.Uint16(announce[96:98]) != 6881 {
+		t.Errorf("Unexpected announce %x", announce)
+	}
+
+	// The connection ID is cached, so a second announce doesn't connect again.
+	if _, err := tracker.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	server.mu.Lock()
+	if server.connects != 1 {
+		t.Errorf("Expected 1 connect, got %v", server.connects)
+	}
+	server.mu.Unlock()
+
+	req.InfoHash = InfoHash("unknownunknownunknow")
+	_, err = tracker.Announce(context.Background(), req)
+	if failure, ok := err.(*TrackerFailure); !ok || failure.Reason != "unknown torrent" {
+		t.Errorf("Expected failure, got %v", err)
+	}
+}
+
+func TestUDPTrackerScrape(t *testing.T) {
+	server := newUDPTestTracker(t)
+	defer server.conn.Close()
+
+	tracker := NewTracker(server.Url())
+	tracker.UDPTimeout = 50 * time.Millisecond
+	// More info hashes than fit in one packet.
+	var infoHashes []InfoHash
+	for i := 0; i < 100; i++ {
+		infoHashes = append(infoHashes, InfoHash(string([]byte{byte(i)})+"aaaaaaaaaaaaaaaaaaa"))
+	}
+	stats, err := tracker.Scrape(context.Background(), infoHashes...)
+	if err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	if len(stats) != len(infoHashes) {
+		t.Errorf("Expected %v stats, got %v", len(infoHashes), len(stats))
+	}
+	expected := ScrapeStats{Complete: 90, Downloaded: 7, Incomplete: 2}
+	if stats[infoHashes[90]] != expected {
+		t.Errorf("Expected %v, Actual %v", expected, stats[infoHashes[90]])
+	}
+}
+
+func TestUDPTrackerAnnounceDualStack(t *testing.T) {
+	server := newUDPTestTracker(t)
+	defer server.conn.Close()
+
+	tracker := NewTracker(server.Url())
+	tracker.UDPTimeout = 50 * time.Millisecond
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	// The tracker only listens on IPv4, so the IPv6 announce fails each time.
+	for i := 0; i < 2; i++ {
+		if resp, err := tracker.AnnounceDualStack(context.Background(), req); err != nil || len(resp.Peers) != 2 {
+			t.Fatalf("Unexpected response %v, err %v", resp, err)
+		}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.connects != 1 || len(server.announces) != 2 {
+		t.Errorf("Expected 1 connect and 2 announces, got %v and %v", server.connects, len(server.announces))
+	}
+}
+
+func TestUDPTrackerTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	defer conn.Close()
+
+	tracker := NewTracker("udp://" + conn.LocalAddr().String())
+	tracker.UDPTimeout = 10 * time.Millisecond
+	tracker.UDPRetries = 2
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	start := time.Now()
+	if _, err := tracker.Announce(context.Background(), req); err == nil {
+		t.Errorf("Expected a timeout")
+	}
+	// 10ms + 20ms + 40ms.
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("Gave up after %v, before retransmitting", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	tracker.UDPTimeout = time.Second
+	if _, err := tracker.Announce(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The deadline passes between retransmissions.
+	ctx, cancel = context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+	tracker.UDPTimeout = 10 * time.Millisecond
+	if _, err := tracker.Announce(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
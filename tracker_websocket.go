@@ -0,0 +1,454 @@
+package gotorrent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebSocket trackers, used by WebTorrent, speak JSON over WebSockets.  Browser peers send
+// WebRTC offers with their announces, which the tracker relays to other peers in the
+// swarm, and the answers are relayed back.  Native clients can't take part in WebRTC, so
+// the client here only announces and scrapes, which is enough to find and count browser
+// swarms.  Info hashes and peer ids are sent as strings with one character per byte.
+
+type wsTrackerMessage struct {
+	Action         string                 `json:"action"`
+	InfoHash       json.RawMessage        `json:"info_hash"`
+	PeerId         string                 `json:"peer_id"`
+	ToPeerId       string                 `json:"to_peer_id"`
+	Event          string                 `json:"event"`
+	Left           *float64               `json:"left"`
+	Offers         []wsOffer              `json:"offers"`
+	Offer          json.RawMessage        `json:"offer"`
+	OfferId        string                 `json:"offer_id"`
+	Answer         json.RawMessage        `json:"answer"`
+	Interval       int                    `json:"interval"`
+	Complete       int                    `json:"complete"`
+	Incomplete     int                    `json:"incomplete"`
+	Files          map[string]ScrapeStats `json:"files"`
+	FailureReason  string                 `json:"failure reason"`
+	WarningMessage string                 `json:"warning message"`
+}
+
+type wsOffer struct {
+	OfferId string          `json:"offer_id"`
+	Offer   json.RawMessage `json:"offer"`
+}
+
+// binaryToJSON converts binary data to the string WebTorrent uses for it, with each byte
+// as the character with that code.
+func binaryToJSON(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
+
+func binaryFromJSON(s string) (string, error) {
+	var b []byte
+	for _, r := range s {
+		if r > 0xff {
+			return "", fmt.Errorf("Invalid binary string %q", s)
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// wsInfoHashes decodes the info_hash of a message, which is a string in announces and a
+// string or a list of strings in scrapes.
+func wsInfoHashes(raw json.RawMessage) ([]InfoHash, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var single string
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, errors.New("Invalid info_hash")
+		}
+		list = []string{single}
+	}
+	var infoHashes []InfoHash
+	for _, s := range list {
+		h, err := binaryFromJSON(s)
+		if err != nil || len(h) != 20 {
+			return nil, errors.New("Invalid info_hash")
+		}
+		infoHashes = append(infoHashes, InfoHash(h))
+	}
+	return infoHashes, nil
+}
+
+// exchangeWebSocket sends request to the tracker on a new connection and returns the
+// first response accepted by match.
+func (t *Tracker) exchangeWebSocket(ctx context.Context, request interface{}, match func(*wsTrackerMessage) bool) (*wsTrackerMessage, error) {
+	conn, err := dialWebSocket(ctx, t.Url, t.Transport)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteMessage(data); err != nil {
+		return nil, err
+	}
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		var response wsTrackerMessage
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, fmt.Errorf("Couldn't parse response from %v: %v", t.Url, err)
+		}
+		if response.FailureReason != "" {
+			return nil, &TrackerFailure{t.Url, response.FailureReason}
+		}
+		// Offers relayed from browser peers are ignored.
+		if match(&response) {
+			return &response, nil
+		}
+	}
+}
+
+func (t *Tracker) announceWebSocket(ctx context.Context, req TrackerRequest) (*TrackerResponse, error) {
+	request := map[string]interface{}{
+		"action":     "announce",
+		"info_hash":  binaryToJSON(string(req.InfoHash)),
+		"peer_id":    binaryToJSON(req.PeerId),
+		"uploaded":   req.Uploaded,
+		"downloaded": req.Downloaded,
+		"left":       req.Left,
+		"numwant":    0,
+		"offers":     []wsOffer{},
+	}
+	if req.Event != "" {
+		request["event"] = req.Event
+	}
+	response, err := t.exchangeWebSocket(ctx, request, func(m *wsTrackerMessage) bool {
+		infoHashes, err := wsInfoHashes(m.InfoHash)
+		return m.Action == "announce" && m.Offer == nil && m.Answer == nil &&
+			err == nil && len(infoHashes) == 1 && infoHashes[0] == req.InfoHash
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TrackerResponse{
+		WarningMessage: response.WarningMessage,
+		Interval:       response.Interval,
+		Complete:       response.Complete,
+		Incomplete:     response.Incomplete,
+	}, nil
+}
+
+func (t *Tracker) scrapeWebSocket(ctx context.Context, infoHashes []InfoHash) (map[InfoHash]ScrapeStats, error) {
+	encoded := make([]string, len(infoHashes))
+	for i, h := range infoHashes {
+		encoded[i] = binaryToJSON(string(h))
+	}
+	request := map[string]interface{}{"action": "scrape", "info_hash": encoded}
+	response, err := t.exchangeWebSocket(ctx, request, func(m *wsTrackerMessage) bool {
+		return m.Action == "scrape"
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := map[InfoHash]ScrapeStats{}
+	for h, s := range response.Files {
+		binary, err := binaryFromJSON(h)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse response from %v: %v", t.Url, err)
+		}
+		stats[InfoHash(binary)] = s
+	}
+	return stats, nil
+}
+
+// wsSwarm is a swarm of WebSocket peers, which can only be reached through the tracker.
+type wsSwarm struct {
+	peers      map[string]*wsPeer // By peer id
+	downloaded int
+}
+
+type wsPeer struct {
+	outbox *wsOutbox
+	seeder bool
+}
+
+const (
+	// wsWriteTimeout limits how long a WebSocket peer may take to accept a message.
+	wsWriteTimeout = 10 * time.Second
+	// wsOutboxSize is how many messages may wait to be sent to a WebSocket peer.  A peer
+	// which falls further behind is disconnected.
+	wsOutboxSize = 64
+)
+
+// wsOutbox sends messages to a WebSocket connection from a single goroutine, so that a
+// slow peer only holds up its own messages.
+type wsOutbox struct {
+	conn  *wsConn
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newWSOutbox(conn *wsConn) *wsOutbox {
+	o := &wsOutbox{conn: conn, queue: make(chan []byte, wsOutboxSize), done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+// run writes queued messages until the outbox is closed, then writes any that are left
+// and closes the connection.
+func (o *wsOutbox) run() {
+	defer o.conn.Close()
+	for {
+		select {
+		case data := <-o.queue:
+			if err := o.conn.WriteMessage(data); err != nil {
+				return
+			}
+		case <-o.done:
+			for {
+				select {
+				case data := <-o.queue:
+					if err := o.conn.WriteMessage(data); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send queues v to be sent as JSON.  If the queue is full the connection is closed.
+func (o *wsOutbox) send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	select {
+	case <-o.done:
+	case o.queue <- data:
+	default:
+		o.conn.Close()
+	}
+}
+
+// close sends the queued messages and closes the connection.
+func (o *wsOutbox) close() {
+	o.once.Do(func() { close(o.done) })
+}
+
+func (sw *wsSwarm) stats() map[string]int {
+	stats := map[string]int{"complete": 0, "incomplete": 0, "downloaded": sw.downloaded}
+	for _, peer := range sw.peers {
+		if peer.seeder {
+			stats["complete"]++
+		} else {
+			stats["incomplete"]++
+		}
+	}
+	return stats
+}
+
+func (s *TrackerServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	// Peers re-announce every interval, so one which is silent for two has gone.
+	conn.readTimeout = 2 * s.WebSocketInterval
+	conn.writeTimeout = wsWriteTimeout
+	outbox := newWSOutbox(conn)
+	// joined maps the swarms this connection is in to its peer id in each.
+	joined := map[InfoHash]string{}
+	defer func() {
+		s.wsMu.Lock()
+		for h, peerId := range joined {
+			s.removeWebSocketPeer(h, peerId, outbox)
+		}
+		s.wsMu.Unlock()
+		outbox.close()
+	}()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsTrackerMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			outbox.send(map[string]interface{}{"failure reason": "Invalid message"})
+			return
+		}
+		var response map[string]interface{}
+		switch msg.Action {
+		case "announce":
+			response, err = s.webSocketAnnounce(outbox, &msg, joined)
+		case "scrape":
+			response, err = s.webSocketScrape(&msg)
+		default:
+			err = errors.New("Unknown action")
+		}
+		if err != nil {
+			response = map[string]interface{}{"failure reason": err.Error()}
+			if msg.Action != "" {
+				response["action"] = msg.Action
+			}
+		}
+		if response != nil {
+			outbox.send(response)
+		}
+	}
+}
+
+// webSocketAnnounce handles an announce from the connection with outbox, and returns the
+// response to send, if any.
+func (s *TrackerServer) webSocketAnnounce(outbox *wsOutbox, msg *wsTrackerMessage, joined map[InfoHash]string) (map[string]interface{}, error) {
+	infoHashes, err := wsInfoHashes(msg.InfoHash)
+	if err != nil || len(infoHashes) != 1 {
+		return nil, errors.New("Invalid info_hash")
+	}
+	infoHash := infoHashes[0]
+	peerId, err := binaryFromJSON(msg.PeerId)
+	if err != nil || len(peerId) != 20 {
+		return nil, errors.New("Invalid peer_id")
+	}
+	if !s.Store.Allowed(infoHash) {
+		return nil, errors.New("Unregistered torrent")
+	}
+	if joinedAs, ok := joined[infoHash]; ok && joinedAs != peerId {
+		return nil, errors.New("Peer id changed")
+	}
+
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	if msg.Answer != nil {
+		// Answers go back to the peer which made the offer, and need no response.
+		toPeerId, _ := binaryFromJSON(msg.ToPeerId)
+		if sw, ok := s.wsSwarms[infoHash]; ok && sw.peers[toPeerId] != nil {
+			to := sw.peers[toPeerId]
+			to.outbox.send(map[string]interface{}{
+				"action":    "announce",
+				"answer":    msg.Answer,
+				"offer_id":  msg.OfferId,
+				"peer_id":   msg.PeerId,
+				"info_hash": binaryToJSON(string(infoHash)),
+			})
+		}
+		return nil, nil
+	}
+
+	sw := s.wsSwarms[infoHash]
+	if sw == nil {
+		sw = &wsSwarm{peers: map[string]*wsPeer{}}
+		s.wsSwarms[infoHash] = sw
+	}
+	if msg.Event == EventStopped {
+		s.removeWebSocketPeer(infoHash, peerId, outbox)
+		delete(joined, infoHash)
+	} else {
+		peer := sw.peers[peerId]
+		if peer != nil && peer.outbox != outbox {
+			// A peer which reconnects takes over its peer id once the old connection
+			// has closed, so that no other client can take its offers and answers.
+			if !peer.outbox.conn.isClosed() {
+				return nil, errors.New("Peer id in use")
+			}
+			peer.outbox = outbox
+		}
+		if peer == nil {
+			peer = &wsPeer{outbox: outbox}
+			sw.peers[peerId] = peer
+		}
+		if msg.Event == EventCompleted && !peer.seeder {
+			sw.downloaded++
+		}
+		peer.seeder = msg.Left != nil && *msg.Left == 0
+		joined[infoHash] = peerId
+
+		// Each offer goes to a different peer.  Map iteration order is random, so the
+		// peers are picked at random.
+		offers := msg.Offers
+		for otherId, other := range sw.peers {
+			if len(offers) == 0 {
+				break
+			}
+			if otherId == peerId {
+				continue
+			}
+			other.outbox.send(map[string]interface{}{
+				"action":    "announce",
+				"offer":     offers[0].Offer,
+				"offer_id":  offers[0].OfferId,
+				"peer_id":   msg.PeerId,
+				"info_hash": binaryToJSON(string(infoHash)),
+			})
+			offers = offers[1:]
+		}
+	}
+
+	response := map[string]interface{}{
+		"action":    "announce",
+		"interval":  int(s.WebSocketInterval / time.Second),
+		"info_hash": binaryToJSON(string(infoHash)),
+	}
+	for key, value := range sw.stats() {
+		response[key] = value
+	}
+	return response, nil
+}
+
+// removeWebSocketPeer removes the peer from the swarm, if it is still on the connection
+// with outbox.  s.wsMu must be held.
+func (s *TrackerServer) removeWebSocketPeer(infoHash InfoHash, peerId string, outbox *wsOutbox) {
+	sw, ok := s.wsSwarms[infoHash]
+	if !ok {
+		return
+	}
+	if peer, ok := sw.peers[peerId]; ok && peer.outbox == outbox {
+		delete(sw.peers, peerId)
+	}
+	if len(sw.peers) == 0 && sw.downloaded == 0 {
+		delete(s.wsSwarms, infoHash)
+	}
+}
+
+func (s *TrackerServer) webSocketScrape(msg *wsTrackerMessage) (map[string]interface{}, error) {
+	infoHashes, err := wsInfoHashes(msg.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	if len(infoHashes) == 0 {
+		for h := range s.wsSwarms {
+			infoHashes = append(infoHashes, h)
+		}
+	}
+	files := map[string]interface{}{}
+	for _, h := range infoHashes {
+		if sw, ok := s.wsSwarms[h]; ok {
+			files[binaryToJSON(string(h))] = sw.stats()
+		}
+	}
+	return map[string]interface{}{"action": "scrape", "files": files}, nil
+}
@@ -0,0 +1,229 @@
+package gotorrent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browserPeer stands in for a WebTorrent peer, which keeps its connection to the tracker
+// open to receive offers and answers.
+type browserPeer struct {
+	t      *testing.T
+	conn   *wsConn
+	peerId string
+}
+
+func newBrowserPeer(t *testing.T, trackerUrl, peerId string) *browserPeer {
+	conn, err := dialWebSocket(context.Background(), trackerUrl, nil)
+	if err != nil {
+		t.Fatalf("Couldn't connect to %v: %v", trackerUrl, err)
+	}
+	return &browserPeer{t, conn, peerId}
+}
+
+func writeWebSocketJSON(conn *wsConn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(data)
+}
+
+func (p *browserPeer) send(message map[string]interface{}) {
+	message["peer_id"] = binaryToJSON(p.peerId)
+	if err := writeWebSocketJSON(p.conn, message); err != nil {
+		p.t.Fatalf("Couldn't send %v: %v", message, err)
+	}
+}
+
+func (p *browserPeer) receive() *wsTrackerMessage {
+	data, err := p.conn.ReadMessage()
+	if err != nil {
+		p.t.Fatalf("Couldn't read message: %v", err)
+	}
+	var message wsTrackerMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		p.t.Fatalf("Couldn't parse %s: %v", data, err)
+	}
+	return &message
+}
+
+func TestWebSocketTracker(t *testing.T) {
+	server := NewTrackerServer(NewSwarmStore())
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	trackerUrl := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	infoHash := InfoHash("\x00\x01\x80\xffaaaaaaaaaaaaaaaa")
+
+	seeder := newBrowserPeer(t, trackerUrl, "-WW0100-seederseeder")
+	defer seeder.conn.Close()
+	seeder.send(map[string]interface{}{
+		"action": "announce", "info_hash": binaryToJSON(string(infoHash)), "left": 0,
+		"event": "started", "offers": []wsOffer{},
+	})
+	response := seeder.receive()
+	if response.Action != "announce" || response.Complete != 1 || response.Interval != 120 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	leecher := newBrowserPeer(t, trackerUrl, "-WW0100-leecherleech")
+	defer leecher.conn.Close()
+	leecher.send(map[string]interface{}{
+		"action": "announce", "info_hash": binaryToJSON(string(infoHash)), "left": 100,
+		"offers": []wsOffer{{"offer1", json.RawMessage(`{"type":"offer","sdp":"x"}`)}},
+	})
+	if response := leecher.receive(); response.Complete != 1 || response.Incomplete != 1 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	// The offer is relayed to the seeder, whose answer goes back to the leecher.
+	offer := seeder.receive()
+	if offer.OfferId != "offer1" || string(offer.Offer) != `{"type":"offer","sdp":"x"}` ||
+		offer.PeerId != binaryToJSON(leecher.peerId) {
+		t.Errorf("Unexpected offer %+v", offer)
+	}
+	seeder.send(map[string]interface{}{
+		"action": "announce", "info_hash": binaryToJSON(string(infoHash)),
+		"to_peer_id": offer.PeerId, "offer_id": offer.OfferId,
+		"answer": json.RawMessage(`{"type":"answer","sdp":"y"}`),
+	})
+	answer := leecher.receive()
+	if answer.OfferId != "offer1" || string(answer.Answer) != `{"type":"answer","sdp":"y"}` ||
+		answer.PeerId != binaryToJSON(seeder.peerId) {
+		t.Errorf("Unexpected answer %+v", answer)
+	}
+
+	// Native clients can count the browser swarm.
+	tracker := NewTracker(trackerUrl)
+	req := TestTrackerRequest
+	req.InfoHash = infoHash
+	req.Left = 100
+	resp, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if resp.Complete != 1 || resp.Incomplete != 2 || resp.Interval != 120 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	stats, err := tracker.Scrape(context.Background(), infoHash, InfoHash("unknownunknownunknow"))
+	if err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Errorf("Unexpected scrape %v", stats)
+	}
+	// The native client disconnected after announcing, which removes it from the swarm.
+	waitForWebSocketStats(t, tracker, infoHash, ScrapeStats{Complete: 1, Incomplete: 1})
+	leecher.conn.Close()
+	waitForWebSocketStats(t, tracker, infoHash, ScrapeStats{Complete: 1})
+
+	server.Store.SetWhitelist([]InfoHash{})
+	_, err = tracker.Announce(context.Background(), req)
+	if failure, ok := err.(*TrackerFailure); !ok || failure.Reason != "Unregistered torrent" {
+		t.Errorf("Expected failure, got %v", err)
+	}
+}
+
+func TestWebSocketTrackerReconnect(t *testing.T) {
+	server := NewTrackerServer(NewSwarmStore())
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	trackerUrl := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	infoHash := InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	announce := func(p *browserPeer, left int, offers []wsOffer) {
+		p.send(map[string]interface{}{
+			"action": "announce", "info_hash": binaryToJSON(string(infoHash)), "left": left,
+			"offers": offers,
+		})
+	}
+	offer := func(id string) []wsOffer {
+		return []wsOffer{{id, json.RawMessage(`{"type":"offer","sdp":"x"}`)}}
+	}
+
+	// Another connection can't take the peer id while the seeder's connection is open.
+	old := newBrowserPeer(t, trackerUrl, "-WW0100-seederseeder")
+	defer old.conn.Close()
+	announce(old, 0, []wsOffer{})
+	old.receive()
+	seeder := newBrowserPeer(t, trackerUrl, old.peerId)
+	defer seeder.conn.Close()
+	seeder.conn.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	announce(seeder, 0, []wsOffer{})
+	if response := seeder.receive(); response.FailureReason != "Peer id in use" {
+		t.Errorf("Expected a failure, got %+v", response)
+	}
+	leecher := newBrowserPeer(t, trackerUrl, "-WW0100-leecherleech")
+	defer leecher.conn.Close()
+	announce(leecher, 100, offer("offer1"))
+	leecher.receive()
+	if offer := old.receive(); offer.OfferId != "offer1" {
+		t.Errorf("Expected the offer on the old connection, got %+v", offer)
+	}
+
+	// Once the old connection has closed, the seeder can announce on its new one.
+	old.conn.Close()
+	waitForWebSocketStats(t, NewTracker(trackerUrl), infoHash, ScrapeStats{Incomplete: 1})
+	announce(seeder, 0, []wsOffer{})
+	if response := seeder.receive(); response.Complete != 1 || response.Incomplete != 1 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	announce(leecher, 100, offer("offer2"))
+	leecher.receive()
+	if offer := seeder.receive(); offer.OfferId != "offer2" {
+		t.Errorf("Expected the offer on the new connection, got %+v", offer)
+	}
+}
+
+func TestWebSocketTrackerIdleTimeout(t *testing.T) {
+	server := NewTrackerServer(NewSwarmStore())
+	server.WebSocketInterval = 10 * time.Millisecond
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// A peer which sends nothing is disconnected.
+	peer := newBrowserPeer(t, "ws"+strings.TrimPrefix(httpServer.URL, "http"), "-WW0100-silentsilent")
+	defer peer.conn.Close()
+	peer.conn.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.conn.ReadMessage(); err == nil || os.IsTimeout(err) {
+		t.Errorf("Expected the tracker to close the connection, got %v", err)
+	}
+}
+
+// waitForWebSocketStats waits for the scrape of infoHash to return expected, since peers
+// leave the swarm when the tracker notices their connection has closed.
+func waitForWebSocketStats(t *testing.T, tracker *Tracker, infoHash InfoHash, expected ScrapeStats) {
+	var stats map[InfoHash]ScrapeStats
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		stats, _ = tracker.Scrape(context.Background(), infoHash)
+		if stats[infoHash] == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected %v, Actual %v", expected, stats[infoHash])
+}
+
+func TestWebSocketTrackerTLS(t *testing.T) {
+	httpServer := httptest.NewTLSServer(NewTrackerServer(NewSwarmStore()))
+	defer httpServer.Close()
+
+	tracker := NewTracker("wss" + strings.TrimPrefix(httpServer.URL, "https") + "/announce")
+	tracker.Transport = &TrackerTransport{
+		TLSConfig: httpServer.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+	req := TestTrackerRequest
+	req.InfoHash = InfoHash("aaaaaaaaaaaaaaaaaaaa")
+	resp, err := tracker.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error announcing: %v", err)
+	}
+	if resp.Complete != 1 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
@@ -0,0 +1,123 @@
+package gotorrent
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"sync"
+
+	"github.com/optimality/gotorrent/bitfield"
+)
+
+// FileReport describes how much of a single file is present and correct.
+type FileReport struct {
+	Path   string // Slash separated path relative to the store directory
+	Length int
+	// Verified is the number of bytes of the file which are in pieces that passed
+	// verification.
+	Verified int
+	// Missing is true if the file does not exist.
+	Missing bool
+	// WrongSize is true if the file exists but is not the expected length.
+	WrongSize bool
+}
+
+// Percent returns the percentage of the file which has been verified.
+func (f FileReport) Percent() float64 {
+	if f.Length == 0 {
+		if f.Missing {
+			return 0
+		}
+		return 100
+	}
+	return 100 * float64(f.Verified) / float64(f.Length)
+}
+
+// Mismatched reports whether the file exists but contains data which failed verification.
+func (f FileReport) Mismatched() bool {
+	return !f.Missing && (f.WrongSize || f.Verified < f.Length)
+}
+
+// Verification is the result of checking the data in a FileStore against the piece hashes.
+type Verification struct {
+	// Pieces has a bit set for each piece which is present and correct.
+	Pieces *bitfield.Bitfield
+	// Files has a report for every file in the torrent except padding files.
+	Files []FileReport
+}
+
+// Complete reports whether every piece passed verification.
+func (v *Verification) Complete() bool {
+	return v.Pieces.All()
+}
+
+// VerifiedPieces returns the number of pieces which passed verification.
+func (v *Verification) VerifiedPieces() int {
+	return v.Pieces.Count()
+}
+
+// Verify hashes every piece already on disk, using workers goroutines, and records the
+// pieces which pass as present in the store.  If workers is 0, one is used per CPU.
+// Missing and unreadable files are reported rather than returned as errors.
+func (s *FileStore) Verify(workers int) *Verification {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	numPieces := s.info.NumPieces()
+	v := &Verification{Pieces: s.info.NewBitfield()}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var piecesMu sync.Mutex
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				data, err := s.ReadPiece(index)
+				if err == nil && s.info.VerifyPiece(index, data) {
+					// Pieces share bytes of the bitfield, so workers take turns to set them.
+					piecesMu.Lock()
+					v.Pieces.Set(index)
+					piecesMu.Unlock()
+				}
+			}
+		}()
+	}
+	for index := 0; index < numPieces; index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	s.mu.Lock()
+	s.have = v.Pieces.Clone()
+	s.mu.Unlock()
+
+	files := s.info.FileList()
+	verified := make([]int, len(files))
+	for index := v.Pieces.NextSet(0); index >= 0; index = v.Pieces.NextSet(index + 1) {
+		for _, span := range s.info.PieceSpans(index) {
+			verified[span.File] += span.Length
+		}
+	}
+	for i, file := range files {
+		if file.IsPadding() {
+			continue
+		}
+		report := FileReport{
+			Path:     path.Join(s.paths[i]...),
+			Length:   file.Length,
+			Verified: verified[i],
+		}
+		stat, err := os.Lstat(s.FilePath(i))
+		if err != nil {
+			report.Missing = true
+			report.Verified = 0
+		} else if !file.IsSymlink() && stat.Size() != int64(file.Length) {
+			report.WrongSize = true
+		}
+		v.Files = append(v.Files, report)
+	}
+	return v
+}
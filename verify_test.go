@@ -0,0 +1,50 @@
+package gotorrent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	metaInfo := makeTestMetaInfo("files", 8, webSeedTestFiles)
+	writeTestFiles(t, filepath.Join(dir, "files"), webSeedTestFiles)
+	store := NewFileStore(dir, &metaInfo.Info)
+
+	v := store.Verify(0)
+	if !v.Complete() || v.VerifiedPieces() != metaInfo.Info.NumPieces() {
+		t.Errorf("Expected complete verification, Actual %v", v.Pieces)
+	}
+	for _, file := range v.Files {
+		if file.Percent() != 100 || file.Mismatched() {
+			t.Errorf("Expected %v to be complete, Actual %+v", file.Path, file)
+		}
+	}
+
+	// Corrupt the end of b.txt and remove c.txt.
+	writeTestFiles(t, filepath.Join(dir, "files"), []testFile{{[]string{"sub dir", "b.txt"}, "jumps ovex"}})
+	if err := os.Remove(filepath.Join(dir, "files", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	v = store.Verify(3)
+	expectedPieces := "0-2"
+	if v.Pieces.Len() != 6 || v.Pieces.String() != expectedPieces {
+		t.Errorf("Expected %v, Actual %v", expectedPieces, v.Pieces)
+	}
+	expectedFiles := []FileReport{
+		{Path: "files/a.txt", Length: 19, Verified: 19},
+		{Path: "files/sub dir/b.txt", Length: 10, Verified: 5},
+		{Path: "files/c.txt", Length: 13, Missing: true},
+	}
+	if !reflect.DeepEqual(expectedFiles, v.Files) {
+		t.Errorf("Expected %+v, Actual %+v", expectedFiles, v.Files)
+	}
+	if v.Files[1].Percent() != 50 || !v.Files[1].Mismatched() || v.Files[2].Mismatched() {
+		t.Errorf("Unexpected report for %+v", v.Files)
+	}
+	if !store.HasPiece(2) || store.HasPiece(3) {
+		t.Errorf("Expected Verify to update the store")
+	}
+}
@@ -0,0 +1,188 @@
+package gotorrent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WebSeed downloads pieces of a torrent from an HTTP server.
+// Two kinds of web seed are supported: plain HTTP servers holding copies of the torrent's
+// files (BEP 19, the url-list key), from which pieces are fetched with Range requests, and
+// seed scripts serving pieces by index (BEP 17, the httpseeds key).
+type WebSeed struct {
+	Url string
+	// HttpSeed is true for BEP 17 seeds.
+	HttpSeed bool
+	// Client is used to make requests.  If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	metaInfo *MetaInfo
+}
+
+// NewWebSeeds returns a WebSeed for every url-list and httpseeds entry in metaInfo.
+func NewWebSeeds(metaInfo *MetaInfo) []*WebSeed {
+	var seeds []*WebSeed
+	for _, u := range metaInfo.Url_List {
+		seeds = append(seeds, &WebSeed{Url: u, metaInfo: metaInfo})
+	}
+	for _, u := range metaInfo.Httpseeds {
+		seeds = append(seeds, &WebSeed{Url: u, HttpSeed: true, metaInfo: metaInfo})
+	}
+	return seeds
+}
+
+func (w *WebSeed) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+// Download fetches every piece which is not already in store, verifies it against its
+// piece hash and writes it to store.  It stops at the first error.
+func (w *WebSeed) Download(ctx context.Context, store PieceStore) error {
+	info := &w.metaInfo.Info
+	for index := 0; index < info.NumPieces(); index++ {
+		if store.HasPiece(index) {
+			continue
+		}
+		data, err := w.DownloadPiece(ctx, index)
+		if err != nil {
+			return err
+		}
+		if err := store.WritePiece(index, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DownloadPiece fetches the piece at index and verifies it against its piece hash.
+func (w *WebSeed) DownloadPiece(ctx context.Context, index int) ([]byte, error) {
+	info := &w.metaInfo.Info
+	if index < 0 || index >= info.NumPieces() {
+		return nil, fmt.Errorf("Invalid piece index %v", index)
+	}
+	var data []byte
+	var err error
+	if w.HttpSeed {
+		data, err = w.fetchHttpSeedPiece(ctx, index)
+	} else {
+		data, err = w.fetchPieceRanges(ctx, index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.VerifyPiece(index, data) {
+		return nil, fmt.Errorf("Hash mismatch for piece %v from web seed %v", index, w.Url)
+	}
+	return data, nil
+}
+
+// fetchPieceRanges fetches a piece from a BEP 19 seed, making one Range request for each
+// file the piece spans.
+func (w *WebSeed) fetchPieceRanges(ctx context.Context, index int) ([]byte, error) {
+	files := w.metaInfo.Info.FileList()
+	data := make([]byte, 0, w.metaInfo.Info.PieceSize(index))
+	for _, span := range w.metaInfo.Info.PieceSpans(index) {
+		if files[span.File].IsPadding() {
+			// Padding files are all zeros, and web seeds don't have them.
+			data = append(data, make([]byte, span.Length)...)
+			continue
+		}
+		spanData, err := w.fetchRange(ctx, w.fileUrl(files[span.File]), span.Offset, span.Length)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, spanData...)
+	}
+	return data, nil
+}
+
+// fileUrl returns the url of file on a BEP 19 seed.  Urls for multi-file torrents, and urls
+// ending in a slash, refer to a directory containing the files.
+func (w *WebSeed) fileUrl(file File) string {
+	if len(w.metaInfo.Info.Files) == 0 && !strings.HasSuffix(w.Url, "/") {
+		return w.Url
+	}
+	u := w.Url
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	escaped := make([]string, len(file.Path))
+	for i, component := range file.Path {
+		escaped[i] = url.PathEscape(component)
+	}
+	return u + strings.Join(escaped, "/")
+}
+
+func (w *WebSeed) fetchRange(ctx context.Context, fileUrl string, offset, length int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fileUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create request for %v: %v", fileUrl, err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range, so skip to the part we want.
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, int64(offset)); err != nil {
+			return nil, fmt.Errorf("Short response from %v: %v", fileUrl, err)
+		}
+	default:
+		return nil, fmt.Errorf("Non-206 response from %v: %v", fileUrl, resp.Status)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("Short response from %v: %v", fileUrl, err)
+	}
+	return data, nil
+}
+
+// fetchHttpSeedPiece fetches a piece from a BEP 17 seed.
+func (w *WebSeed) fetchHttpSeedPiece(ctx context.Context, index int) ([]byte, error) {
+	seedUrl, err := url.Parse(w.Url)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse url %v", w.Url)
+	}
+	appendQuery(seedUrl, "info_hash="+escapeQueryBytes(string(w.metaInfo.InfoHash))+"&piece="+strconv.Itoa(index))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", seedUrl.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create request for %v: %v", seedUrl, err)
+	}
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		// The body holds the number of seconds to wait before retrying.
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 32))
+		return nil, fmt.Errorf("Http seed %v is busy, retry in %v seconds", w.Url, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Non-200 response from %v: %v", w.Url, resp.Status)
+	}
+	size := w.metaInfo.Info.PieceSize(index)
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("Wrong length %v for piece %v from %v, expected %v",
+			len(data), index, w.Url, size)
+	}
+	return data, nil
+}
@@ -0,0 +1,160 @@
+package gotorrent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var webSeedTestFiles = []testFile{
+	{[]string{"a.txt"}, "the quick brown fox"},
+	{[]string{"sub dir", "b.txt"}, "jumps over"},
+	{[]string{"c.txt"}, " the lazy dog"},
+}
+
+func writeTestFiles(t *testing.T, dir string, files []testFile) {
+	for _, file := range files {
+		path := filepath.Join(append([]string{dir}, file.path...)...)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkTestFiles(t *testing.T, dir string, files []testFile) {
+	for _, file := range files {
+		path := filepath.Join(append([]string{dir}, file.path...)...)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("Unable to read %v: %v", path, err)
+			continue
+		}
+		if string(b) != file.data {
+			t.Errorf("Expected %q in %v, Actual %q", file.data, path, string(b))
+		}
+	}
+}
+
+func TestWebSeedDownload(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, filepath.Join(root, "files"), webSeedTestFiles)
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	metaInfo := makeTestMetaInfo("files", 8, webSeedTestFiles)
+	metaInfo.Url_List = UrlList{server.URL}
+	seeds := NewWebSeeds(&metaInfo)
+	if len(seeds) != 1 {
+		t.Fatalf("Expected 1 web seed, Actual %v", len(seeds))
+	}
+
+	dir := t.TempDir()
+	store := NewFileStore(dir, &metaInfo.Info)
+	if err := seeds[0].Download(context.Background(), store); err != nil {
+		t.Fatalf("Error downloading: %v", err)
+	}
+	for i := 0; i < metaInfo.Info.NumPieces(); i++ {
+		if !store.HasPiece(i) {
+			t.Errorf("Missing piece %v", i)
+		}
+	}
+	checkTestFiles(t, filepath.Join(dir, "files"), webSeedTestFiles)
+}
+
+func TestWebSeedSingleFile(t *testing.T) {
+	data := "a single file torrent"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/single.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "single.txt", time.Time{}, strings.NewReader(data))
+	}))
+	defer server.Close()
+
+	metaInfo := makeTestMetaInfo("single.txt", 4, []testFile{{nil, data}})
+	for _, u := range []string{server.URL + "/", server.URL + "/single.txt"} {
+		seed := &WebSeed{Url: u, metaInfo: &metaInfo}
+		piece, err := seed.DownloadPiece(context.Background(), 2)
+		if err != nil {
+			t.Errorf("Error downloading from %v: %v", u, err)
+		} else if string(piece) != data[8:12] {
+			t.Errorf("Expected %q, Actual %q", data[8:12], piece)
+		}
+	}
+}
+
+func TestWebSeedHashMismatch(t *testing.T) {
+	root := t.TempDir()
+	corrupt := []testFile{webSeedTestFiles[0], {webSeedTestFiles[1].path, "jumps ov3r"}, webSeedTestFiles[2]}
+	writeTestFiles(t, filepath.Join(root, "files"), corrupt)
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	metaInfo := makeTestMetaInfo("files", 8, webSeedTestFiles)
+	seed := &WebSeed{Url: server.URL + "/", metaInfo: &metaInfo}
+	if _, err := seed.DownloadPiece(context.Background(), 0); err != nil {
+		t.Errorf("Unexpected error for intact piece: %v", err)
+	}
+	if _, err := seed.DownloadPiece(context.Background(), 3); err == nil {
+		t.Errorf("Expected hash mismatch for corrupt piece")
+	}
+}
+
+func TestHttpSeed(t *testing.T) {
+	metaInfo := makeTestMetaInfo("files", 8, webSeedTestFiles)
+	var all string
+	for _, file := range webSeedTestFiles {
+		all += file.data
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The seed's own parameters are kept as they were, and the info hash is escaped
+		// byte by byte.
+		prefix := "passkey=a%2Fb&info_hash=" + escapeQueryBytes(string(metaInfo.InfoHash)) + "&piece="
+		if !strings.HasPrefix(r.URL.RawQuery, prefix) {
+			http.Error(w, "unknown torrent", http.StatusNotFound)
+			return
+		}
+		piece, err := strconv.Atoi(strings.TrimPrefix(r.URL.RawQuery, prefix))
+		if err != nil {
+			http.Error(w, "bad piece", http.StatusBadRequest)
+			return
+		}
+		if piece == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("30"))
+			return
+		}
+		end := (piece + 1) * 8
+		if end > len(all) {
+			end = len(all)
+		}
+		w.Write([]byte(all[piece*8 : end]))
+	}))
+	defer server.Close()
+
+	metaInfo.Httpseeds = UrlList{server.URL + "/seed.php?passkey=a%2Fb"}
+	seeds := NewWebSeeds(&metaInfo)
+	if len(seeds) != 1 || !seeds[0].HttpSeed {
+		t.Fatalf("Expected 1 http seed, Actual %v", seeds)
+	}
+	piece, err := seeds[0].DownloadPiece(context.Background(), 5)
+	if err != nil {
+		t.Errorf("Error downloading: %v", err)
+	} else if string(piece) != all[40:] {
+		t.Errorf("Expected %q, Actual %q", all[40:], piece)
+	}
+	if _, err := seeds[0].DownloadPiece(context.Background(), 1); err == nil {
+		t.Errorf("Expected error from busy seed")
+	}
+}
@@ -0,0 +1,330 @@
+package gotorrent
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal WebSocket implementation (RFC 6455), enough for WebSocket trackers.  Only
+// complete text and binary messages are exposed; control frames are handled internally.
+
+const (
+	wsOpContinuation = 0
+	wsOpText         = 1
+	wsOpBinary       = 2
+	wsOpClose        = 8
+	wsOpPing         = 9
+	wsOpPong         = 10
+
+	wsGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxWebSocketMessageSize limits the size of messages read.
+	maxWebSocketMessageSize = 1 << 20
+)
+
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// client connections mask the frames they send, and servers' frames are unmasked.
+	client bool
+	// readTimeout and writeTimeout, if set, bound the time to read or write each frame.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGuid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// dialWebSocket opens a WebSocket connection to a ws:// or wss:// url.
+func dialWebSocket(ctx context.Context, wsUrl string, transport *TrackerTransport) (*wsConn, error) {
+	u, err := url.Parse(wsUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse url %v", wsUrl)
+	}
+	host := u.Host
+	var conn net.Conn
+	var dialer net.Dialer
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		tlsDialer := tls.Dialer{NetDialer: &dialer}
+		if transport != nil && transport.TLSConfig != nil {
+			tlsDialer.Config = transport.TLSConfig.Clone()
+		}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("Unsupported WebSocket url %v", wsUrl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The handshake is bounded by the context, like the dial.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	httpUrl := *u
+	httpUrl.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	req, err := http.NewRequest("GET", httpUrl.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if transport != nil {
+		transport.addHeaders(req)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake with %v failed: %v", wsUrl, resp.Status)
+	}
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return &wsConn{conn: conn, reader: reader, client: true}, nil
+}
+
+// isWebSocketUpgrade reports whether r asks to upgrade to a WebSocket connection.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+func headerContainsToken(header http.Header, key, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the server side of the WebSocket handshake for r.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || !isWebSocketUpgrade(r) || key == "" {
+		http.Error(w, "Bad WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("Bad WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("Unsupported WebSocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets unsupported", http.StatusInternalServerError)
+		return nil, errors.New("ResponseWriter can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// ReadMessage returns the next text or binary message.  It answers pings, and returns
+// io.EOF once the other end closes the connection.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, nil)
+			c.Close()
+			return nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, errors.New("Expected a WebSocket continuation frame")
+			}
+			started = true
+		case wsOpContinuation:
+			if !started {
+				return nil, errors.New("Unexpected WebSocket continuation frame")
+			}
+		default:
+			return nil, fmt.Errorf("Unknown WebSocket opcode %v", opcode)
+		}
+		if len(message)+len(payload) > maxWebSocketMessageSize {
+			return nil, errors.New("WebSocket message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, errors.New("WebSocket frame masked incorrectly")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxWebSocketMessageSize {
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends data as a single text message.  It is safe to call concurrently.
+func (c *wsConn) WriteMessage(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// isClosed reports whether Close has been called.
+func (c *wsConn) isClosed() bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.closed
+}
+
+// Close closes the connection without the closing handshake.
+func (c *wsConn) Close() error {
+	c.writeMu.Lock()
+	c.closed = true
+	c.writeMu.Unlock()
+	return c.conn.Close()
+}
@@ -0,0 +1,86 @@
+package gotorrent
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func newTestWebSocketPair() (client, server *wsConn) {
+	a, b := net.Pipe()
+	client = &wsConn{conn: a, reader: bufio.NewReader(a), client: true}
+	server = &wsConn{conn: b, reader: bufio.NewReader(b)}
+	return client, server
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example from RFC 6455.
+	if accept := webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept %v", accept)
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	client, server := newTestWebSocketPair()
+	defer client.Close()
+	defer server.Close()
+
+	for _, size := range []int{0, 125, 126, 65535, 65536, 200000} {
+		message := bytes.Repeat([]byte("x"), size)
+		go client.WriteMessage(message)
+		received, err := server.ReadMessage()
+		if err != nil || !bytes.Equal(received, message) {
+			t.Errorf("Message of %v bytes received as %v bytes, %v", size, len(received), err)
+		}
+		go server.WriteMessage(message)
+		received, err = client.ReadMessage()
+		if err != nil || !bytes.Equal(received, message) {
+			t.Errorf("Message of %v bytes received as %v bytes, %v", size, len(received), err)
+		}
+	}
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	client, server := newTestWebSocketPair()
+	defer client.Close()
+
+	// A fragmented message with a ping in the middle.
+	go func() {
+		client.conn.Write([]byte{wsOpText, 0x80 | 3, 0, 0, 0, 0, 'a', 'b', 'c'})
+		client.writeFrame(wsOpPing, []byte("ping"))
+		client.conn.Write([]byte{0x80 | wsOpContinuation, 0x80 | 2, 1, 1, 1, 1, 'd' ^ 1, 'e' ^ 1})
+	}()
+	pong := make(chan []byte)
+	go func() {
+		message, _ := client.ReadMessage()
+		pong <- message
+	}()
+	message, err := server.ReadMessage()
+	if err != nil || string(message) != "abcde" {
+		t.Errorf("Expected abcde, got %q, %v", message, err)
+	}
+
+	// The pong is swallowed by the client, which then sees the close.
+	go server.writeFrame(wsOpClose, nil)
+	// The client echoes the close.
+	if _, err := server.ReadMessage(); err != io.EOF {
+		t.Errorf("Expected EOF after close, got %v", err)
+	}
+	if message := <-pong; message != nil {
+		t.Errorf("Unexpected message %q", message)
+	}
+}
+
+func TestWebSocketRejectsUnmaskedClientFrames(t *testing.T) {
+	client, server := newTestWebSocketPair()
+	defer client.Close()
+	defer server.Close()
+
+	go client.conn.Write([]byte{0x80 | wsOpText, 1, 'a'})
+	if _, err := server.ReadMessage(); err == nil || !strings.Contains(err.Error(), "masked") {
+		t.Errorf("Expected an error for an unmasked frame, got %v", err)
+	}
+}